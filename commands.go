@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wouterbeets/cookieDb/dataset"
+)
+
+//commands are run instead of the analysis when their name is the first argument
+var commands = map[string]func(args []string) error{
+	"migrate":  migrate,
+	"compact":  compact,
+	"verify":   verify,
+	"gc":       gc,
+	"erase":    erase,
+	"snapshot": snapshot,
+	"restore":  restore,
+}
+
+//shardFiles returns the shard files in args, directories are searched for them
+func shardFiles(args []string) ([]string, error) {
+	var names []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(path, ".gob") {
+				names = append(names, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+//migrate gives the headerless shards in args a header,
+//their categories are added to the dictionary of their store
+func migrate(args []string) error {
+	names, err := shardFiles(args)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	dictDir := ""
+	for _, name := range names {
+		if dir := cookieDb.StoreDirOf(name); dir != dictDir {
+			if cookieDb.Categories, err = cookieDb.LoadDict(cookieDb.DictPath(dir)); err != nil {
+				return err
+			}
+			dictDir = dir
+		}
+		migrated, err := cookieDb.MigrateShard(name)
+		switch {
+		case err != nil:
+			failed++
+			errors.Println(err)
+			fmt.Println("failed", name, err)
+		case migrated:
+			fmt.Println("migrated", name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d shards could not be migrated", failed, len(names))
+	}
+	return nil
+}
+
+//compact merges the shards in args, which are of one type, into one shard. When it is written to the dir of a store
+//it is cataloged in place of the shards of its store, which main loads it for.
+func compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	out := fs.String("out", "", "file the merged shard is written to")
+	indexed := fs.Bool("indexed", false, "write the merged shard in the indexed format")
+	remove := fs.Bool("remove", false, "remove the merged shards and their sidecar files once the merged shard is written")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("usage: compact -out merged.gob [-indexed] [-remove] shard...")
+	}
+	names, err := shardFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(*out)
+	if _, err := os.Stat(cookieDb.CatalogPath(dir)); err == nil {
+		return compactStore(dir, *out, names, *indexed, *remove)
+	}
+	h, err := cookieDb.CompactShards(*out, names, *indexed)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %d shards into %s, %d cookies from %s to %s\n", len(names), *out, h.Count, h.Start, h.End)
+	if !*remove {
+		return nil
+	}
+	for _, name := range names {
+		if name == *out {
+			continue
+		}
+		if err := cookieDb.RemoveShard(name); err != nil {
+			return err
+		}
+		fmt.Println("removed", name)
+	}
+	return nil
+}
+
+//compactStore merges the shards names of the store in dir into out with Store.Compact, the manifest entries of the
+//shards it removes are dropped
+func compactStore(dir, out string, names []string, indexed, remove bool) error {
+	store, err := cookieDb.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	e, err := store.Compact(out, names, indexed, remove)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %d shards into %s, %d cookies from %s to %s\n", len(names), out, e.Count, e.Start, e.End)
+	if !remove {
+		return nil
+	}
+	manifest, err := cookieDb.LoadManifest(cookieDb.ManifestPath(dir))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := manifest.Delete(name); err != nil {
+			return err
+		}
+		fmt.Println("removed", name)
+	}
+	return nil
+}
+
+//verifyReport is what verify prints
+type verifyReport struct {
+	Checked int                      `json:"checked"`
+	Failed  int                      `json:"failed"`
+	Shards  []*cookieDb.Verification `json:"shards"`
+}
+
+//verify checks the shards in args and prints a json report of them,
+//the categories of a shard are looked up in the dictionary of its store
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	history := fs.Duration("history", 0, "how long before the hour of its file an event may be, 0 takes any earlier event as history of its cookie")
+	fs.Parse(args)
+	names, err := shardFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+	report := verifyReport{Shards: []*cookieDb.Verification{}}
+	dictDir := ""
+	for _, name := range names {
+		if dir := cookieDb.StoreDirOf(name); dir != dictDir {
+			if cookieDb.Categories, err = cookieDb.LoadDict(cookieDb.DictPath(dir)); err != nil {
+				return err
+			}
+			dictDir = dir
+		}
+		v := cookieDb.VerifyShard(name, cookieDb.VerifyOptions{History: *history})
+		report.Checked++
+		if !v.OK() {
+			report.Failed++
+		}
+		report.Shards = append(report.Shards, v)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d shards failed verification", report.Failed, report.Checked)
+	}
+	return nil
+}
+
+//gc drops the shards and events of the store in args that are past its retention, and those of the shards
+//outside its catalog that follow it, like merged shards written elsewhere
+func gc(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	config := fs.String("retention", "", "retention config, retention.json in the store by default")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: gc [-retention retention.json] store [shard...]")
+	}
+	dir := fs.Arg(0)
+	if *config == "" {
+		*config = cookieDb.RetentionPath(dir)
+	}
+	retention, err := cookieDb.LoadRetention(*config)
+	if err != nil {
+		return err
+	}
+	if len(retention) == 0 {
+		return fmt.Errorf("%s sets no retention", *config)
+	}
+	if cookieDb.Categories, err = cookieDb.LoadDict(cookieDb.DictPath(dir)); err != nil {
+		return err
+	}
+	s, err := cookieDb.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	names, err := shardFiles(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	var outside []string
+	for _, name := range names {
+		//the partitions are pruned by GC with their time index
+		if !s.Has(name) && filepath.Dir(name) != filepath.Clean(s.PartitionsDir()) {
+			outside = append(outside, name)
+		}
+	}
+	report, err := s.GC(retention, time.Now())
+	if err == nil && len(outside) > 0 {
+		var more *cookieDb.GCReport
+		more, err = cookieDb.GCShards(outside, retention, time.Now())
+		report.Dropped = append(report.Dropped, more.Dropped...)
+		report.Pruned = append(report.Pruned, more.Pruned...)
+		report.Events += more.Events
+		report.Cookies += more.Cookies
+	}
+	for _, name := range report.Dropped {
+		fmt.Println("dropped", name)
+	}
+	for _, name := range report.Pruned {
+		fmt.Println("pruned", name)
+	}
+	fmt.Println("pruned", report.Events, "events and", report.Cookies, "cookies")
+	return err
+}
+
+//erase removes the cookie ids in args, and those in the -ids file, from every shard of a store
+func erase(args []string) error {
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	idFile := fs.String("ids", "", "file with a cookie id per line to erase")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: erase [-ids file] store [id...]")
+	}
+	dir, ids := fs.Arg(0), fs.Args()[1:]
+	if *idFile != "" {
+		f, err := os.Open(*idFile)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no cookie ids to erase")
+	}
+	var err error
+	if cookieDb.Categories, err = cookieDb.LoadDict(cookieDb.DictPath(dir)); err != nil {
+		return err
+	}
+	s, err := cookieDb.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	records, err := s.Erase(ids, time.Now())
+	for i, r := range records {
+		fmt.Println("erased", ids[i], "from", len(r.Shards), "files, audit id", r.IDHash)
+	}
+	return err
+}
+
+//snapshot writes the store in args to one tar archive, gzipped when its name ends in .gz
+func snapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := fs.String("o", "", "archive to write")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *out == "" {
+		return fmt.Errorf("usage: snapshot -o archive.tar[.gz] store")
+	}
+	s, err := cookieDb.OpenStore(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	tmp := *out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(*out, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	manifest, err := s.Snapshot(w)
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	fmt.Println("archived", manifest.Shards, "shards in", len(manifest.Files), "files to", *out)
+	return nil
+}
+
+//restore unpacks the snapshot archive in args into a new store dir, with -move the shards are pointed at their
+//logs in the dir they were moved to
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	move := fs.String("move", "", "old=new, the logs of the store were moved from the dir old to the dir new")
+	fs.Parse(args)
+	from, to := "", ""
+	if *move != "" {
+		i := strings.Index(*move, "=")
+		if i < 1 || i == len(*move)-1 {
+			return fmt.Errorf("-move takes old=new, got %q", *move)
+		}
+		from, to = (*move)[:i], (*move)[i+1:]
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: restore [-move old=new] archive.tar[.gz] store")
+	}
+	r, err := cookieDb.OpenInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	manifest, err := cookieDb.Restore(r, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Println("restored", manifest.Shards, "shards in", len(manifest.Files), "files to", fs.Arg(1))
+	if from == "" {
+		return nil
+	}
+	s, err := cookieDb.OpenStore(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	moved, err := s.MoveSources(from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Println("moved the logs of", moved, "shards from", from, "to", to)
+	return nil
+}
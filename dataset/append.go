@@ -0,0 +1,109 @@
+package cookieDb
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//AppendShard adds the lines of the late input file source, read by scanner, to the shard stored in shardName,
+//the same way FillDb adds them, and writes the shard back. The lines are decoded in the format of source, and
+//get the hour of the shard as if they were in its file: FileTimes remembers source as being in that hour.
+//The shard on disk is replaced in one rename, it is left as it was when anything fails.
+func AppendShard(shardName, source string, scanner *bufio.Scanner, opts *FillOptions) (*Report, error) {
+	hour, err := FileTimes.Extract(shardName)
+	if err != nil {
+		return nil, err
+	}
+	d, h, err := readShard(shardName)
+	if err != nil {
+		return nil, err
+	}
+	write := writeShard
+	if s, ok := d.(readOnlyShard); ok {
+		d, err = s.Load()
+		s.Close()
+		if err != nil {
+			return nil, err
+		}
+		write = writeIndexedShard
+	}
+	FileTimes.Remember(source, hour)
+	d, report, err := FillDb(scanner, d, source, opts)
+	if err != nil {
+		return report, err
+	}
+	return report, replaceShard(shardName, d, func(tmp string) error { return write(tmp, d, h.rewrite(d)) })
+}
+
+//readOnlyShard is an indexed shard file that is opened for reading
+type readOnlyShard interface {
+	Shard
+	Load() (Shard, error)
+	Close() error
+}
+
+//replaceShard calls write with a temporary file next to fileName and renames the file over fileName,
+//then the Bloom filter of fileName is rewritten for d. Temporary files left by a crash are removed by Recover.
+//The filter of the replaced shard is removed before the rename: a crash before the new filter is written leaves
+//a shard without one, which may hold any cookie, never a shard next to a filter that misses its cookies.
+func replaceShard(fileName string, d Shard, write func(tmp string) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Remove(BloomPath(fileName)); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(fileName))
+	return writeBloom(fileName, d)
+}
+
+//replaceFile writes data to fileName in one rename, a file that is not a shard is replaced like replaceShard does
+func replaceFile(fileName string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(fileName))
+	return nil
+}
+
+//syncDir makes a rename in dir durable, where the platform can not sync directories it does nothing
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
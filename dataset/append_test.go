@@ -0,0 +1,105 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendShard(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		newShard func() Shard
+		count    int
+	}{
+		{func() Shard { d := make(Intersection); return &d }, 1},
+		{func() Shard { d := make(CountTimeSet); return &d }, 2},
+		{func() Shard { d := make(CountTimeCatsSet); return &d }, 2},
+		{func() Shard { d := make(StatSet); return &d }, 2},
+	}
+	for _, test := range testCases {
+		d := test.newShard()
+		shardName := dir + "/foo_2016111100.log." + d.Type() + ".gob"
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), d, shardName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteShard(shardName, d); err != nil {
+			t.Fatal(err)
+		}
+		late := "BhrVPRR199e9aC8R\t1478840500:3\nnewCookie\t1478840501:4\nbroken"
+		report, err := AppendShard(shardName, "late_2016111100.log", bufio.NewScanner(strings.NewReader(late)), nil)
+		if err != nil {
+			t.Fatal(d.Type(), err)
+		}
+		if report.Lines != 3 || report.Rejected != 1 {
+			t.Error(d.Type(), "wrong report", report)
+		}
+		s, err := ReadShard(shardName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Size() != 2 || s.Get("newCookie") == nil {
+			t.Error(d.Type(), "late cookie is missing")
+		}
+		if c := s.Get("BhrVPRR199e9aC8R"); c.Count() != test.count {
+			t.Error(d.Type(), "wrong count after append", c.Count())
+		}
+
+		if _, err := AppendShard(shardName, "late_2016111100.log", bufio.NewScanner(strings.NewReader("broken")), &FillOptions{Policy: AbortOnBadLine}); err == nil {
+			t.Error(d.Type(), "expected the append to abort")
+		}
+		if s, _ := ReadShard(shardName); s.Size() != 2 {
+			t.Error(d.Type(), "aborted append changed the shard")
+		}
+	}
+	if tmps, _ := filepath.Glob(dir + "/*.tmp"); len(tmps) != 0 {
+		t.Error("temporary files are left behind", tmps)
+	}
+
+	source := dir + "/foo_2016111100.log"
+	late := dir + "/late_2016111100.log"
+	os.WriteFile(source, []byte(LINE), 0644)
+	os.WriteFile(late, []byte("newCookie\t1478840501:4"), 0644)
+	m, _ := LoadManifest(ManifestPath(dir))
+	shardName := source + ".StatSet.gob"
+	if err := m.Record(shardName, "StatSet", source, CurrentOptions(SkipBadLines)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RecordAppend(shardName, late); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = LoadManifest(ManifestPath(dir))
+	if e := m.Entry(shardName); len(e.Appended) != 1 || e.Appended[0].Path != late || e.Source.Path != source {
+		t.Error("manifest does not record the appended input", e)
+	}
+	if err := m.RecordAppend(source+".Intersection.gob", late); err == nil {
+		t.Error("expected an error for an input appended to a shard that is not recorded")
+	}
+}
+
+func TestAppendShardFormat(t *testing.T) {
+	shardName := t.TempDir() + "/foo_2016111100.log.StatSet.gob"
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &StatSet{}, shardName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(shardName, d); err != nil {
+		t.Fatal(err)
+	}
+	late := `{"cookie": "newCookie", "events": [{"ts": 1478840501, "cats": ["4"]}]}`
+	report, err := AppendShard(shardName, "late_2016111101.jsonl", bufio.NewScanner(strings.NewReader(late)), &FillOptions{Policy: AbortOnBadLine})
+	if err != nil || report.Lines != 1 {
+		t.Fatal("late lines are not decoded in the format of their file", err)
+	}
+	s, err := ReadShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the late file holds the next hour, its lines are in the hour of the shard
+	if u := s.Get("newCookie").User(); u == nil || u.Sess[0].Hist {
+		t.Error("late lines did not get the hour of the shard", u)
+	}
+}
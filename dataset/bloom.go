@@ -0,0 +1,114 @@
+package cookieDb
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+)
+
+//BloomFPRate is the false positive rate of the Bloom filters written next to the shards, below 1.
+//No filter is written when it is 0.
+var BloomFPRate = 0.01
+
+//Bloom is a Bloom filter of cookie ids, it can tell that a shard does not hold a cookie without loading the shard
+type Bloom struct {
+	Bits []uint64
+	K    int
+}
+
+//NewBloom returns a filter sized for n ids at the false positive rate fpRate
+func NewBloom(n int, fpRate float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{Bits: make([]uint64, (int(m)+63)/64), K: k}
+}
+
+//positions calls f with the k bit positions of id, derived from two halves of one FNV hash
+func (b *Bloom) positions(id string, f func(pos uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(b.Bits)) * 64
+	for i := 0; i < b.K; i++ {
+		if !f((h1 + uint64(i)*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+//Add adds id to the filter
+func (b *Bloom) Add(id string) {
+	b.positions(id, func(pos uint64) bool {
+		b.Bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+//MayContain is false when id was never added, and true when it was or for a false positive
+func (b *Bloom) MayContain(id string) bool {
+	if len(b.Bits) == 0 {
+		return true
+	}
+	return b.positions(id, func(pos uint64) bool {
+		return b.Bits[pos/64]&(1<<(pos%64)) != 0
+	})
+}
+
+//BloomPath returns the file next to shardName that holds its Bloom filter
+func BloomPath(shardName string) string {
+	return strings.TrimSuffix(shardName, ".gob") + ".bloom"
+}
+
+//ReadBloom reads the Bloom filter of shardName
+func ReadBloom(shardName string) (*Bloom, error) {
+	f, err := os.Open(BloomPath(shardName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := new(Bloom)
+	return b, gob.NewDecoder(f).Decode(b)
+}
+
+//writeBloom writes the Bloom filter of the cookies of d next to shardName, a filter of an earlier
+//version of the shard is removed when BloomFPRate is 0
+func writeBloom(shardName string, d Shard) error {
+	path := BloomPath(shardName)
+	if BloomFPRate <= 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if BloomFPRate >= 1 {
+		return fmt.Errorf("bloom filter false positive rate %v is not below 1", BloomFPRate)
+	}
+	b := NewBloom(d.Size(), BloomFPRate)
+	for _, c := range d.GetElems(d.Size()) {
+		b.Add(c.ID())
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
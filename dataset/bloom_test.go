@@ -0,0 +1,68 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+	b := NewBloom(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		b.Add(fmt.Sprint("cookie", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !b.MayContain(fmt.Sprint("cookie", i)) {
+			t.Fatal("false negative for cookie", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if b.MayContain(fmt.Sprint("other", i)) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Error("false positive rate is too high", float64(positives)/10000)
+	}
+}
+
+func TestShardBloom(t *testing.T) {
+	dir := t.TempDir()
+	d := make(CountTimeSet)
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(manyCookies(100))), &d, "test_2016111100.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, write := range []func(string, Shard) error{WriteShard, WriteIndexedShard} {
+		shardName := dir + "/test_2016111100.log.CountTimeSet.gob"
+		if err := write(shardName, &d); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ReadBloom(shardName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !b.MayContain("cookie0042") || b.MayContain("not in the shard") {
+			t.Error("wrong bloom filter")
+		}
+		if _, err := AppendShard(shardName, "late_2016111100.log", bufio.NewScanner(strings.NewReader("late\t1478840400:3")), nil); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ = ReadBloom(shardName); !b.MayContain("late") {
+			t.Error("bloom filter was not updated by the append")
+		}
+		os.Remove(BloomPath(shardName))
+	}
+
+	BloomFPRate = 0
+	defer func() { BloomFPRate = 0.01 }()
+	shardName := dir + "/nobloom.gob"
+	if err := WriteShard(shardName, &d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBloom(shardName); !os.IsNotExist(err) {
+		t.Error("bloom filter was written with a rate of 0", err)
+	}
+}
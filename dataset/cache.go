@@ -0,0 +1,174 @@
+package cookieDb
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//cookieBytes is the estimated memory of one decoded cookie of each shard type, its id included
+var cookieBytes = map[string]int64{
+	"Intersection":     64,
+	"CountTimeSet":     192,
+	"CountTimeCatsSet": 320,
+	"StatSet":          1024,
+}
+
+//indexEntryBytes is the estimated memory of one entry of the index of a read only shard,
+//the records stay on disk or in pages the kernel manages
+const indexEntryBytes = 64
+
+//EstimateSize returns the estimated memory held by the shard d
+func EstimateSize(d Shard) int64 {
+	switch s := d.(type) {
+	case *IndexedShard:
+		return int64(len(s.index)) * indexEntryBytes
+	case *MappedShard:
+		return int64(len(s.index)) * indexEntryBytes
+	}
+	perCookie, ok := cookieBytes[d.Type()]
+	if !ok {
+		perCookie = cookieBytes["StatSet"]
+	}
+	return int64(d.Size()) * perCookie
+}
+
+//CacheStats counts the work of a ShardCache
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Shards    int
+	Bytes     int64
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %d evictions, %d shards in %d bytes", s.Hits, s.Misses, s.Evictions, s.Shards, s.Bytes)
+}
+
+//ShardCache keeps the shards read by ReadShard in memory and drops the least recently used ones when their
+//EstimateSize adds up to more than Budget. It is safe for concurrent use, a shard is read once however many
+//readers ask for it at the same time.
+type ShardCache struct {
+	Budget int64
+	mu     sync.Mutex
+	lru    *list.List
+	shards map[string]*list.Element
+	stats  CacheStats
+}
+
+type cacheEntry struct {
+	name    string
+	d       Shard
+	err     error
+	size    int64
+	refs    int
+	evicted bool
+	ready   chan struct{}
+}
+
+//NewShardCache returns an empty cache of budget bytes
+func NewShardCache(budget int64) *ShardCache {
+	return &ShardCache{Budget: budget, lru: list.New(), shards: make(map[string]*list.Element)}
+}
+
+//Get returns the shard in the file name, read from disk when it is not cached.
+//release has to be called when the caller is done with the shard, an evicted shard is closed after its last release.
+func (c *ShardCache) Get(name string) (d Shard, release func(), err error) {
+	c.mu.Lock()
+	if el, ok := c.shards[name]; ok {
+		e := el.Value.(*cacheEntry)
+		e.refs++
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		c.mu.Unlock()
+		<-e.ready
+		if e.err != nil {
+			c.release(e)
+			return nil, func() {}, e.err
+		}
+		return e.d, func() { c.release(e) }, nil
+	}
+	e := &cacheEntry{name: name, refs: 1, ready: make(chan struct{})}
+	c.shards[name] = c.lru.PushFront(e)
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	d, err = ReadShard(name)
+	c.mu.Lock()
+	e.d, e.err = d, err
+	if e.err != nil {
+		c.remove(e)
+	} else {
+		e.size = EstimateSize(e.d)
+		c.stats.Bytes += e.size
+		c.evict()
+	}
+	c.mu.Unlock()
+	close(e.ready)
+	if e.err != nil {
+		c.release(e)
+		return nil, func() {}, e.err
+	}
+	return e.d, func() { c.release(e) }, nil
+}
+
+//evict drops the least recently used shards until the cache fits its budget, shards still being read are kept
+func (c *ShardCache) evict() {
+	for el := c.lru.Back(); el != nil && c.stats.Bytes > c.Budget; {
+		prev := el.Prev()
+		if e := el.Value.(*cacheEntry); e.d != nil {
+			c.remove(e)
+			c.stats.Evictions++
+		}
+		el = prev
+	}
+}
+
+//remove takes e out of the cache, it is closed once it is released
+func (c *ShardCache) remove(e *cacheEntry) {
+	if e.evicted {
+		return
+	}
+	e.evicted = true
+	c.lru.Remove(c.shards[e.name])
+	delete(c.shards, e.name)
+	c.stats.Bytes -= e.size
+	if e.refs == 0 {
+		closeShard(e.d)
+	}
+}
+
+func (c *ShardCache) release(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.refs--
+	if e.refs == 0 && e.evicted {
+		closeShard(e.d)
+	}
+}
+
+func closeShard(d Shard) {
+	if c, ok := d.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+//Stats returns the hits, misses and evictions so far and what the cache holds now
+func (c *ShardCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Shards = len(c.shards)
+	return s
+}
+
+//Close drops every shard, the ones still in use are closed when they are released
+func (c *ShardCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.shards {
+		c.remove(el.Value.(*cacheEntry))
+	}
+}
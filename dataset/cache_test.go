@@ -0,0 +1,65 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestShardCache(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 3; i++ {
+		shardName := fmt.Sprintf("%s/foo_201611110%d.log.StatSet.gob", dir, i)
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &StatSet{}, shardName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteShard(shardName, d); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, shardName)
+	}
+	size := EstimateSize(&StatSet{"a": nil})
+	c := NewShardCache(2 * size)
+	var wg sync.WaitGroup
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, release, err := c.Get(names[0])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if d.Get("BhrVPRR199e9aC8R") == nil {
+				t.Error("cached shard lost its cookie")
+			}
+			release()
+		}()
+	}
+	wg.Wait()
+	if s := c.Stats(); s.Misses != 1 || s.Hits != 7 {
+		t.Error("shard was read more than once", s)
+	}
+	for _, name := range []string{names[1], names[0], names[2], names[1]} {
+		_, release, err := c.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	s := c.Stats()
+	if s.Misses != 4 || s.Hits != 8 || s.Evictions != 2 || s.Shards != 2 || s.Bytes != 2*size {
+		t.Error("wrong stats", s)
+	}
+	if _, _, err := c.Get(dir + "/missing.gob"); err == nil {
+		t.Error("expected an error for a missing shard")
+	}
+	c.Close()
+	if s := c.Stats(); s.Shards != 0 || s.Bytes != 0 {
+		t.Error("closed cache still holds shards", s)
+	}
+}
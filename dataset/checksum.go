@@ -0,0 +1,138 @@
+package cookieDb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Shard files of format version 2 and up end in a footer with the CRC-32C of every byte before it
+
+//checksumSize is the length of the checksum footer
+const checksumSize = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//ErrChecksum is returned for a shard file that is truncated or whose contents do not match its checksum
+var ErrChecksum = errors.New("shard file is damaged")
+
+//footerSize returns the length of the checksum footer of the file h is the header of
+func (h *Header) footerSize() int64 {
+	if h.Version < 2 {
+		return 0
+	}
+	return checksumSize
+}
+
+//writeChecksum writes the footer with the checksum sum to f and syncs f to disk
+func writeChecksum(f *os.File, sum uint32) error {
+	var footer [checksumSize]byte
+	binary.LittleEndian.PutUint32(footer[:], sum)
+	if _, err := f.Write(footer[:]); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+//VerifyChecksum reads the shard file shardName and tells if it matches its checksum, errors that wrap
+//ErrChecksum mean the file is damaged. Shards written before version 2 have no checksum and always match,
+//headerless shards give ErrNoHeader and those of a format version this build can not read ErrUnsupportedVersion.
+func VerifyChecksum(shardName string) error {
+	f, err := os.Open(shardName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var h *Header
+	if isIndexed(r) {
+		h, err = readIndexedHeader(r)
+	} else {
+		h, _, err = readHeader(r)
+	}
+	if err == ErrNoHeader || errors.Is(err, ErrUnsupportedVersion) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrChecksum, err)
+	}
+	if h.footerSize() == 0 {
+		return nil
+	}
+	if info.Size() < checksumSize {
+		return fmt.Errorf("%w: no checksum", ErrChecksum)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := crc32.New(crcTable)
+	if _, err := io.CopyN(sum, f, info.Size()-checksumSize); err != nil {
+		return err
+	}
+	var footer [checksumSize]byte
+	if _, err := io.ReadFull(f, footer[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(footer[:]) != sum.Sum32() {
+		return fmt.Errorf("%w: checksum mismatch", ErrChecksum)
+	}
+	return nil
+}
+
+//Recovery lists what Recover removed
+type Recovery struct {
+	Temps   []string
+	Damaged []string
+}
+
+//Recover cleans up after writes that were cut off in the store dir and the dirs below it. Temporary files are
+//removed, and so are the shards that fail VerifyChecksum with their Bloom filter and Meta, so they are rebuilt.
+//Shards this build can not read are left alone. It returns ErrStoreBusy and touches nothing while another
+//process has the store open.
+func Recover(dir string) (*Recovery, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return new(Recovery), nil
+	}
+	lock, err := lockStore(dir, true, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	rec := new(Recovery)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			//a sidecar of a damaged shard that was removed before the walk got to it
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(path, ".tmp"):
+			rec.Temps = append(rec.Temps, path)
+			return os.Remove(path)
+		case strings.HasSuffix(path, ".gob"):
+			if err := VerifyChecksum(path); !errors.Is(err, ErrChecksum) {
+				return nil
+			}
+			rec.Damaged = append(rec.Damaged, path)
+			for _, p := range []string{path, BloomPath(path), MetaPath(path)} {
+				if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return rec, err
+}
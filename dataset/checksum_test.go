@@ -0,0 +1,83 @@
+package cookieDb
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader(manyCookies(200))), &CountTimeSet{}, "foo_2016111100.log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, flipped, truncated := dir+"/good.gob", dir+"/flipped.gob", dir+"/truncated.gob"
+	for _, name := range []string{good, flipped} {
+		if err := WriteShard(name, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteIndexedShard(truncated, d); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{good, flipped, truncated} {
+		if err := VerifyChecksum(name); err != nil {
+			t.Error(name, err)
+		}
+	}
+	data, _ := os.ReadFile(flipped)
+	data[len(data)/2] ^= 1
+	os.WriteFile(flipped, data, 0644)
+	info, _ := os.Stat(truncated)
+	os.Truncate(truncated, info.Size()/2)
+	for _, name := range []string{flipped, truncated} {
+		if err := VerifyChecksum(name); !errors.Is(err, ErrChecksum) {
+			t.Error(name, "expected a checksum error, got", err)
+		}
+	}
+	os.WriteFile(dir+"/good.gob.123.tmp", data[:10], 0644)
+	newer := dir + "/newer.gob"
+	if err := writeShard(newer, d, &Header{Version: FormatVersion + 1, Type: d.Type(), Count: d.Size()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksum(newer); !errors.Is(err, ErrUnsupportedVersion) || errors.Is(err, ErrChecksum) {
+		t.Error("expected an unsupported version, got", err)
+	}
+
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Recover(dir); err != ErrStoreBusy {
+		t.Error("recovered a store that is open, got", err)
+	}
+	if _, err := os.Stat(dir + "/good.gob.123.tmp"); err != nil {
+		t.Error("busy store lost its temporary file", err)
+	}
+	s.Close()
+
+	rec, err := Recover(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Temps) != 1 || len(rec.Damaged) != 2 {
+		t.Error("wrong recovery", rec)
+	}
+	for _, name := range []string{flipped, truncated, BloomPath(flipped), dir + "/good.gob.123.tmp"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Error(name, "was not removed")
+		}
+	}
+	if s, err := ReadShard(good); err != nil || s.Size() != 200 {
+		t.Error("intact shard was touched", err)
+	}
+	if _, err := os.Stat(newer); err != nil {
+		t.Error("shard of a newer version was removed", err)
+	}
+	if _, err := Recover(dir + "/missing"); err != nil {
+		t.Error(err)
+	}
+}
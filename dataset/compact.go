@@ -0,0 +1,122 @@
+package cookieDb
+
+import (
+	"errors"
+	"fmt"
+)
+
+//mergeShard adds the cookies of src to dst the way Add would have if dst had been filled from the input of both,
+//ids are united, counts summed and timestamps, categories and sessions concatenated
+func mergeShard(dst, src Shard) error {
+	if dst.Type() != src.Type() {
+		return fmt.Errorf("can not merge a %s into a %s", src.Type(), dst.Type())
+	}
+	switch d := dst.(type) {
+	case *Intersection:
+		for id := range *src.(*Intersection) {
+			(*d)[id] = struct{}{}
+		}
+	case *CountTimeSet:
+		for id, c := range *src.(*CountTimeSet) {
+			if cookie, ok := (*d)[id]; ok {
+				cookie.Count += c.Count
+				cookie.TStamp = append(cookie.TStamp, c.TStamp...)
+			} else {
+				(*d)[id] = c
+			}
+		}
+	case *CountTimeCatsSet:
+		for id, c := range *src.(*CountTimeCatsSet) {
+			if cookie, ok := (*d)[id]; ok {
+				cookie.Counter += c.Counter
+				cookie.TStamp = append(cookie.TStamp, c.TStamp...)
+				cookie.Categories = append(cookie.Categories, c.Categories...)
+			} else {
+				(*d)[id] = c
+			}
+		}
+	case *StatSet:
+		for id, u := range *src.(*StatSet) {
+			if user, ok := (*d)[id]; ok {
+				user.Sess = append(user.Sess, u.Sess...)
+			} else {
+				(*d)[id] = u
+			}
+		}
+	default:
+		return fmt.Errorf("%s can not be merged", dst.Type())
+	}
+	return nil
+}
+
+//loadShard reads the whole shard in shardName, decoding indexed shards into the type they were written from
+func loadShard(shardName string) (Shard, *Header, error) {
+	d, h, err := readShard(shardName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s, ok := d.(readOnlyShard); ok {
+		d, err = s.Load()
+		s.Close()
+	}
+	return d, h, err
+}
+
+//hasCategories tells if the shards of shardType hold the ids of categories, which are only known in their store
+func hasCategories(shardType string) bool {
+	return shardType == "CountTimeCatsSet" || shardType == "StatSet"
+}
+
+//CompactShards merges the shards in names, which must be of one type and timezone, into the shard file out.
+//It is written in the indexed format when indexed is set. The header of out spans the time range of all the shards
+//and names them, and the shards they replaced in turn, in Replaces. The shards in names are left in place.
+//Shards with categories are only merged within one store, every store numbers its categories itself.
+func CompactShards(out string, names []string, indexed bool) (*Header, error) {
+	if len(names) == 0 {
+		return nil, errors.New("no shards to compact")
+	}
+	var merged Shard
+	var h *Header
+	var replaces []string
+	storeDir := StoreDirOf(out)
+	for _, name := range names {
+		d, sh, err := loadShard(name)
+		if err != nil {
+			return nil, err
+		}
+		if dir := StoreDirOf(name); hasCategories(d.Type()) && dir != storeDir {
+			return nil, fmt.Errorf("%s is in the store %s, its categories can not be merged into %s in the store %s", name, dir, out, storeDir)
+		}
+		if merged == nil {
+			merged, h = d, sh
+		} else {
+			if sh.Timezone != h.Timezone {
+				return nil, fmt.Errorf("%s is in %s, the other shards are in %s", name, sh.Timezone, h.Timezone)
+			}
+			if err := mergeShard(merged, d); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			if !sh.Start.IsZero() && (h.Start.IsZero() || sh.Start.Before(h.Start)) {
+				h.Start = sh.Start
+			}
+			if sh.End.After(h.End) {
+				h.End = sh.End
+			}
+		}
+		replaces = append(replaces, name)
+		replaces = append(replaces, sh.Replaces...)
+	}
+	header := newHeader(merged, h.Timezone)
+	if !h.Start.IsZero() && (header.Start.IsZero() || h.Start.Before(header.Start)) {
+		header.Start = h.Start
+	}
+	if h.End.After(header.End) {
+		header.End = h.End
+	}
+	header.Replaces = replaces
+	write := writeShard
+	if indexed {
+		write = writeIndexedShard
+	}
+	return header, replaceShard(out, merged, func(tmp string) error { return write(tmp, merged, header) })
+}
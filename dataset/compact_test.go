@@ -0,0 +1,104 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompactShards(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		newShard func() Shard
+		count    int
+	}{
+		{func() Shard { d := make(Intersection); return &d }, 1},
+		{func() Shard { d := make(CountTimeSet); return &d }, 2},
+		{func() Shard { d := make(CountTimeCatsSet); return &d }, 2},
+		{func() Shard { d := make(StatSet); return &d }, 2},
+	}
+	for _, test := range testCases {
+		var names []string
+		for i, input := range []string{LINE, LINE + "\nnewCookie\t1478840501:4"} {
+			shardName := fmt.Sprintf("%s/foo_201611110%d.log.%s.gob", dir, i, test.newShard().Type())
+			d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), test.newShard(), shardName, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				err = WriteShard(shardName, d)
+			} else {
+				err = WriteIndexedShard(shardName, d)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, shardName)
+		}
+		out := dir + "/foo_20161111.log." + test.newShard().Type() + ".gob"
+		h, err := CompactShards(out, names, false)
+		if err != nil {
+			t.Fatal(test.newShard().Type(), err)
+		}
+		if len(h.Replaces) != 2 || h.Replaces[0] != names[0] || h.Replaces[1] != names[1] {
+			t.Error(h.Type, "wrong replaced shards", h.Replaces)
+		}
+		s, err := ReadShard(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Size() != 2 || s.Get("newCookie") == nil {
+			t.Error(h.Type, "wrong cookies after compaction", s.Size())
+		}
+		c := s.Get("BhrVPRR199e9aC8R")
+		if c.Count() != test.count {
+			t.Error(h.Type, "wrong count after compaction", c.Count())
+		}
+		if h.Type != "Intersection" && len(c.Time()) != 4 {
+			t.Error(h.Type, "timestamps of the merged shards are missing", c.Time())
+		}
+		if h.Type != "Intersection" && (h.Start.IsZero() || !h.End.After(h.Start)) {
+			t.Error(h.Type, "wrong time range", h.Start, h.End)
+		}
+		if _, err := ReadBloom(out); err != nil {
+			t.Error(h.Type, "merged shard has no Bloom filter", err)
+		}
+	}
+	a, b := dir+"/foo_2016111100.log.Intersection.gob", dir+"/foo_2016111100.log.StatSet.gob"
+	if _, err := CompactShards(dir+"/mixed.gob", []string{a, b}, false); err == nil {
+		t.Error("expected shards of different types not to be compacted")
+	}
+}
+
+func TestCompactShardsAcrossStores(t *testing.T) {
+	dir := t.TempDir()
+	saved := Categories
+	defer func() { Categories = saved }()
+	var names []string
+	for i, store := range []string{dir + "/a", dir + "/b"} {
+		s, err := OpenStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		//the category 4 gets another id in every store
+		Categories = NewDict(DictPath(store))
+		lines := fmt.Sprintf("cookie%d\t1478840400:%d\ncookie\t1478840401:4", i, i)
+		source := fmt.Sprintf("%s/foo_201611110%d.log", dir, i)
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(lines)), &CountTimeCatsSet{}, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, false); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s.Path(source, "CountTimeCatsSet"))
+	}
+	if _, err := CompactShards(dir+"/a/foo_20161111.log.CountTimeCatsSet.gob", names, false); err == nil {
+		t.Error("shards with the categories of another store are compacted")
+	}
+	if _, err := CompactShards(dir+"/foo_20161111.log.CountTimeCatsSet.gob", names[:1], false); err == nil {
+		t.Error("shard compacted out of its store")
+	}
+}
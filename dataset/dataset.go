@@ -4,14 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log"
 	"os"
 	"sort"
-	"strings"
 	"time"
-
-	"github.com/segmentio/objconv/bytesconv"
 )
 
 //Shard abstracts the dataype that can depend on the analysis needed
@@ -91,7 +91,7 @@ func (c cookieCountTime) User() *User {
 type CountTimeCats struct {
 	Counter    int
 	TStamp     []time.Time
-	Categories []string
+	Categories []CatID
 	CookieID   string
 }
 
@@ -113,55 +113,91 @@ func (c *CountTimeCats) String() string {
 		s += t.In(time.UTC).Format(time.Stamp) + ", "
 	}
 	s += "]"
-	return fmt.Sprint(len(c.Categories), "\t", c.CookieID, "\t", c.Categories, "\t", c.Counter, "\t", len(c.TStamp), "\t", s)
+	return fmt.Sprint(len(c.Categories), "\t", c.CookieID, "\t", Categories.Names(c.Categories), "\t", c.Counter, "\t", len(c.TStamp), "\t", s)
 }
 
 func (c *CountTimeCats) Cats() []string {
-	sort.StringSlice(c.Categories).Sort()
-	return c.Categories
+	cats := Categories.Names(c.Categories)
+	sort.StringSlice(cats).Sort()
+	return cats
 }
 
 func (c *CountTimeCats) User() *User {
 	return nil
 }
 
-func getCats(rawCats []byte) (cats []string) {
-	splits := bytes.Split(rawCats, []byte(","))
-	for _, cat := range splits {
-		cats = append(cats, string(cat))
+func getCats(rawCats []byte) []string {
+	var names []string
+	for _, cat := range bytes.Split(rawCats, []byte(",")) {
+		names = append(names, string(cat))
+	}
+	return names
+}
+
+//setCats gives the events the ids of the category names their decoder found. It is only called once the line
+//is accepted, so lines that are rejected do not add their categories to Categories for good.
+func setCats(events []Event) {
+	for i := range events {
+		if events[i].names != nil {
+			events[i].Cats, events[i].names = Categories.IDs(events[i].names), nil
+		}
 	}
-	return
 }
-func getEvent(rawEvent []byte, fileTime *time.Time) (e Event) {
-	rawData := bytes.Split(rawEvent, []byte(":"))
-	stamp, err := bytesconv.ParseInt(rawData[0], 10, 64)
+
+//parseEvent splits a raw "timestamp:cat,cat" event and parses its timestamp,
+//the categories start after the end of the timestamp found by stampEnd
+func parseEvent(rawEvent []byte) (time.Time, []byte, error) {
+	i := stampEnd(rawEvent)
+	if i == len(rawEvent) {
+		return time.Time{}, nil, newParseError(ReasonMissingCategories, fmt.Errorf("event %q has no categories", rawEvent))
+	}
+	if rawEvent[i] != ':' {
+		return time.Time{}, nil, newParseError(ReasonBadTimestamp, fmt.Errorf("event %q has no ':' after its timestamp", rawEvent))
+	}
+	t, err := parseStamp(rawEvent[:i])
 	if err != nil {
-		log.Println("error parsing timestamp", err)
+		return time.Time{}, nil, err
 	}
-	e.T = time.Unix(stamp, 0)
-	e.Cats = getCats(rawData[1])
-	e.His = e.Hist(fileTime)
+	return t, rawEvent[i+1:], nil
+}
+
+func getEvent(rawEvent []byte) (e Event, err error) {
+	t, rawCats, err := parseEvent(rawEvent)
+	if err != nil {
+		return e, err
+	}
+	e.T = t
+	e.names = getCats(rawCats)
 	return
 }
 
-func getEvents(fields []byte, fileTime *time.Time) (e []Event) {
+func getEvents(fields []byte) (events []Event, err error) {
 	for _, rawEvent := range bytes.Split(fields, []byte(";")) {
-		e = append(e, getEvent(rawEvent, fileTime))
+		e, err := getEvent(rawEvent)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
 	}
 	return
 }
 
-func getSession(line []byte, fileTime *time.Time) (*Session, string) {
-	id, fields := getFields(line)
-	s := new(Session)
-	s.Events = getEvents(fields, fileTime)
-	for _, e := range s.Events {
-		if e.His {
+func getSession(dec LineDecoder, line []byte, fileTime *time.Time) (*Session, string, error) {
+	id, events, err := dec.Decode(line)
+	if err != nil {
+		return nil, "", err
+	}
+	setCats(events)
+	s := &Session{Events: events}
+	for i := range s.Events {
+		//events are kept in the zone of the file so their gob encoding holds the right offset for Session.String
+		s.Events[i].T = s.Events[i].T.In(fileTime.Location())
+		s.Events[i].His = s.Events[i].Hist(fileTime)
+		if s.Events[i].His {
 			s.Hist = true
-			break
 		}
 	}
-	return s, id
+	return s, id, nil
 }
 
 type StatSet map[string]*User
@@ -181,8 +217,14 @@ func (set *StatSet) String() string {
 
 func (set *StatSet) Add(line []byte, fileName string) error {
 	d := *set
-	fileTime := ParseTime(fileName)
-	sess, cookieID := getSession(line, &fileTime)
+	fileTime, err := FileTimes.Extract(fileName)
+	if err != nil {
+		return err
+	}
+	sess, cookieID, err := getSession(DecoderFor(fileName), line, &fileTime)
+	if err != nil {
+		return err
+	}
 	sess.File = fileName
 	if user, ok := d[cookieID]; ok {
 		user.Sess = append(user.Sess, *sess)
@@ -260,7 +302,7 @@ func (u *User) Cats() []string {
 	var cats []string
 	for _, s := range u.Sess {
 		for _, e := range s.Events {
-			cats = append(cats, e.Cats...)
+			cats = append(cats, Categories.Names(e.Cats)...)
 		}
 	}
 	return cats
@@ -298,27 +340,24 @@ func (s *Session) setCurrent(startTime, endTime time.Time) bool {
 func (s *Session) String() string {
 	str := "Session from file: " + s.File + " Hist " + fmt.Sprint(s.Hist) + "\n"
 	for _, e := range s.Events {
-		str += fmt.Sprintf("\t\tevent: { hist: %+v, time: %s, cats: %+v\n", e.His, e.T.In(LOC).Format(time.Stamp), e.Cats)
+		str += fmt.Sprintf("\t\tevent: { hist: %+v, time: %s, cats: %+v\n", e.His, e.T.Format(time.Stamp), Categories.Names(e.Cats))
 	}
 	return str
 }
 
 type Event struct {
 	T       time.Time
-	Cats    []string
+	Cats    []CatID
 	His     bool
 	Current bool
+	//names are the categories found by the decoder, they become Cats once the line is accepted
+	names []string
 }
 
+//Hist tells if the event happened outside of the hour starting at t. The hour is compared as instants,
+//so the hour repeated when daylight saving time ends is not mistaken for the first one.
 func (e Event) Hist(t *time.Time) bool {
-	if e.T.In(LOC).Year() == t.In(LOC).Year() {
-		if e.T.In(LOC).Month() == t.In(LOC).Month() {
-			if e.T.In(LOC).Day() == t.In(LOC).Day() {
-				return !(e.T.In(LOC).Hour() == t.In(LOC).Hour())
-			}
-		}
-	}
-	return true
+	return e.T.Before(*t) || !e.T.Before(t.Add(time.Hour))
 }
 
 func (e *Event) setCurrent(startTime, endTime time.Time) bool {
@@ -330,37 +369,25 @@ func (e *Event) setCurrent(startTime, endTime time.Time) bool {
 	return false
 }
 
-func ParseTime(fileName string) time.Time {
-	t := strings.Split(fileName, "_")[1]
-	timeStr := strings.Split(t, ".")[0]
-	ret, err := time.ParseInLocation("2006010215", timeStr, LOC)
-	if err != nil {
-		panic(err)
-	}
-	return ret
+//ParseTime returns the time of fileName found by FileTimes, or an error when its name holds none
+func ParseTime(fileName string) (time.Time, error) {
+	return FileTimes.Extract(fileName)
 }
 
 type CountTimeCatsSet map[string]*CountTimeCats
 
 func (set *CountTimeCatsSet) Add(line []byte, fileName string) error {
 	d := *set
-	cookieID, fields := getFields(line)
+	cookieID, events, err := DecoderFor(fileName).Decode(line)
+	if err != nil {
+		return err
+	}
+	setCats(events)
 	c := &CountTimeCats{}
-	for _, raw := range bytes.Split(fields, []byte(";")) {
-		rawCats := bytes.Split(raw, []byte(":"))
-		stamp, err := bytesconv.ParseInt(rawCats[0], 10, 64)
-		cats := bytes.Split(rawCats[1], []byte(","))
-		categories := []string{}
-		for _, cat := range cats {
-			categories = append(categories, string(cat))
-		}
-		if err != nil {
-			log.Println(err)
-		}
-		unixStamp := time.Unix(stamp, 0)
-		c.TStamp = append(c.TStamp, unixStamp)
+	for _, e := range events {
+		c.TStamp = append(c.TStamp, e.T)
 		c.Counter = 1
-		c.Categories = append(c.Categories, categories...)
+		c.Categories = append(c.Categories, e.Cats...)
 		c.CookieID = cookieID
 	}
 	if cookie, ok := d[cookieID]; ok {
@@ -379,7 +406,7 @@ func (set *CountTimeCatsSet) String() string {
 	s := ""
 	i := 0
 	for key, val := range *set {
-		s += fmt.Sprint(key, " ", val.TStamp, " ", val.Categories)
+		s += fmt.Sprint(key, " ", val.TStamp, " ", Categories.Names(val.Categories))
 		i++
 		if i == 100 {
 			break
@@ -426,16 +453,13 @@ type CountTimeSet map[string]*CountTime
 
 func (set *CountTimeSet) Add(line []byte, fileName string) error {
 	d := *set
-	cookieID, fields := getFields(line)
+	cookieID, events, err := DecoderFor(fileName).Decode(line)
+	if err != nil {
+		return err
+	}
 	c := &CountTime{}
-	for _, raw := range bytes.Split(fields, []byte(";")) {
-		rawStamp := bytes.Split(raw, []byte(":"))[0]
-		stamp, err := bytesconv.ParseInt(rawStamp, 10, 64)
-		if err != nil {
-			log.Println(err)
-		}
-		unixStamp := time.Unix(stamp, 0)
-		c.TStamp = append(c.TStamp, unixStamp)
+	for _, e := range events {
+		c.TStamp = append(c.TStamp, e.T)
 		c.Count = 1
 	}
 	if cookie, ok := d[cookieID]; ok {
@@ -515,7 +539,10 @@ func (set *Intersection) Init() {
 }
 func (set *Intersection) Add(line []byte, fileName string) error {
 	d := *set
-	cookieID, _ := getFields(line)
+	cookieID, err := DecoderFor(fileName).ID(line)
+	if err != nil {
+		return err
+	}
 	d[cookieID] = struct{}{}
 	return nil
 }
@@ -551,29 +578,60 @@ func (set *Intersection) Get(cookieID string) Cookie {
 	return nil
 }
 
-func FillDb(scanner *bufio.Scanner, d Shard, shardName string) Shard {
-	for scanner.Scan() {
-		d.Add(scanner.Bytes(), shardName)
+func getFields(line []byte) (string, []byte, error) {
+	i := bytes.IndexByte(line, '\t')
+	if i < 0 {
+		return "", nil, newParseError(ReasonMissingFields, errors.New("line has no tab"))
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "reading file input:", err)
+	if i == 0 {
+		return "", nil, newParseError(ReasonEmptyCookieID, errors.New("line has an empty cookie id"))
 	}
-	return d
+	return string(line[:i]), line[i+1:], nil
 }
 
-func getFields(line []byte) (string, []byte) {
-	fields := bytes.Split(line, []byte("\t"))
-	return string(fields[0]), fields[1]
+//ReadShard reads the file pointed to by shardName and returns the it as a dataset,
+//the header of the file is checked against the shard it holds. An indexed shard file is
+//opened as an IndexedShard, or as a MappedShard when MapIndexed is set, instead of being decoded.
+func ReadShard(shardName string) (Shard, error) {
+	d, _, err := readShard(shardName)
+	return d, err
 }
 
-//ReadShard reads the file pointed to by shardName and returns the it as a dataset
-func ReadShard(shardName string) (Shard, error) {
+func readShard(shardName string) (Shard, *Header, error) {
 	f, err := os.Open(shardName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	dec := gob.NewDecoder(f)
-	return readDecoder(dec)
+	r := bufio.NewReader(f)
+	if isIndexed(r) && MapIndexed {
+		f.Close()
+		s, err := OpenMappedShard(shardName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Header(), nil
+	}
+	if isIndexed(r) {
+		f.Close()
+		s, err := OpenIndexedShard(shardName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Header(), nil
+	}
+	defer f.Close()
+	h, dec, err := readHeader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", shardName, err)
+	}
+	d, err := readDecoder(dec)
+	if err != nil {
+		return d, h, err
+	}
+	if err := h.check(d); err != nil {
+		return d, h, fmt.Errorf("%s: %w", shardName, err)
+	}
+	return d, h, nil
 }
 
 func readDecoder(dec *gob.Decoder) (Shard, error) {
@@ -585,16 +643,40 @@ func readDecoder(dec *gob.Decoder) (Shard, error) {
 	return d, nil
 }
 
-//WriteShard writes the dataset to a file for later use
+//WriteShard writes the dataset to a file for later use, Categories is saved first so
+//that every category id in the file can be decoded. The header gets the timezone of FileTimes.
+//The file is written next to fileName and renamed into place, so a cut off write leaves no partial shard.
+//The Bloom filter of the shard is written next to it.
 func WriteShard(fileName string, d Shard) error {
+	h := newHeader(d, FileTimes.Loc().String())
+	return replaceShard(fileName, d, func(tmp string) error { return writeShard(tmp, d, h) })
+}
+
+func writeShard(fileName string, d Shard, h *Header) error {
+	if err := Categories.Save(); err != nil {
+		return err
+	}
 	f, err := os.Create(fileName)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	_ = f
-	enc := gob.NewEncoder(f)
-	return writeToEncoder(enc, d)
+	sum := crc32.New(crcTable)
+	w := bufio.NewWriter(io.MultiWriter(f, sum))
+	enc, err := writeHeader(w, h)
+	if err != nil {
+		return err
+	}
+	if err := writeToEncoder(enc, d); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := writeChecksum(f, sum.Sum32()); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
 func writeToEncoder(enc *gob.Encoder, d Shard) error {
@@ -605,6 +687,11 @@ func writeToEncoder(enc *gob.Encoder, d Shard) error {
 	return nil
 }
 
+//DefaultTimezone is the timezone of datasets that do not set one
+const DefaultTimezone = "America/New_York"
+
+//LOC is the location of DefaultTimezone, it is UTC when the timezone database is missing.
+//LOC.String() is the name of the zone that is actually used.
 var LOC *time.Location
 
 func init() {
@@ -617,6 +704,10 @@ func init() {
 		panic(err)
 	}
 	log.SetOutput(f)
-	loc, err := time.LoadLocation("America/New_York")
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		log.Println("loading", DefaultTimezone, "failed, falling back to UTC:", err)
+		loc = time.UTC
+	}
 	LOC = loc
 }
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -40,16 +41,20 @@ func TestParse(t *testing.T) {
 			d:    &d3,
 		},
 	}
+	shardName := t.TempDir() + "/foo.gob"
 	for i, test := range testCases {
-		d := FillDb(test.data, test.d, "test_2016111100.log")
+		d, _, err := FillDb(test.data, test.d, "test_2016111100.log", nil)
+		if err != nil {
+			t.Error(err)
+		}
 		if d.Size() == 0 {
 			t.Error("failed for test", test, i)
 		}
-		err := WriteShard("foo.gob", d)
+		err = WriteShard(shardName, d)
 		if err != nil {
 			t.Error(err)
 		}
-		s, err := ReadShard("foo.gob")
+		s, err := ReadShard(shardName)
 		fmt.Println(s)
 		if s.Size() == 0 {
 			t.Error("failed for test", test, i)
@@ -61,8 +66,14 @@ var LINE = "BhrVPRR199e9aC8R	1479585192:142416,158621,158628,179159,287247,49186
 
 func TestGetSession(t *testing.T) {
 	fileName := "artefact_2016120601.log"
-	ti := ParseTime(fileName)
-	ses, cookieID := getSession([]byte(LINE), &ti)
+	ti, err := ParseTime(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ses, cookieID, err := getSession(TSV, []byte(LINE), &ti)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(ses.Events) != 2 {
 		t.Error("len event != 2")
 	}
@@ -74,16 +85,19 @@ func TestGetSession(t *testing.T) {
 
 func TestEventHist(t *testing.T) {
 	fileName := "artefact_2016120601.log"
-	ti := ParseTime(fileName)
+	ti, err := ParseTime(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Println(ti.In(time.UTC))
 	fmt.Println("hour", ti.In(time.UTC).Hour())
 	eventTime := time.Date(2016, 12, 6, 1, 1, 0, 0, LOC)
-	e := Event{T: eventTime, Cats: []string{"1", "2"}}
+	e := Event{T: eventTime, Cats: Categories.IDs([]string{"1", "2"})}
 	if e.Hist(&ti) {
 		t.Error("not working")
 	}
 	eventTime = time.Date(2016, 12, 6, 0, 1, 0, 0, LOC)
-	e = Event{T: eventTime, Cats: []string{"1", "2"}}
+	e = Event{T: eventTime, Cats: Categories.IDs([]string{"1", "2"})}
 	if !e.Hist(&ti) {
 		t.Error("not working")
 	}
@@ -91,24 +105,60 @@ func TestEventHist(t *testing.T) {
 
 func TestEventCurrent(t *testing.T) {
 	fileName := "artefact_2016120601.log"
-	endTime := ParseTime(fileName).Add(time.Duration(time.Hour))
+	fileTime, err := ParseTime(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endTime := fileTime.Add(time.Duration(time.Hour))
 	startTime := endTime.Add(time.Duration(time.Hour * 12 * -1))
 	eventTime := time.Date(2016, 12, 6, 1, 1, 0, 0, LOC)
-	e := &Event{T: eventTime, Cats: []string{"1", "2"}}
+	e := &Event{T: eventTime, Cats: Categories.IDs([]string{"1", "2"})}
 	e.setCurrent(startTime, endTime)
 	if !e.Current {
 		t.Error("event is current", e.Current, e.T.In(LOC).Format(time.Stamp), startTime.In(LOC).Format(time.Stamp), endTime.In(LOC).Format(time.Stamp))
 	}
 	eventTime = time.Date(2016, 12, 6, 3, 1, 0, 0, LOC)
-	e = &Event{T: eventTime, Cats: []string{"1", "2"}}
+	e = &Event{T: eventTime, Cats: Categories.IDs([]string{"1", "2"})}
 	e.setCurrent(startTime, endTime)
 	if e.Current {
 		t.Error("event is not current")
 	}
 	eventTime = time.Date(2016, 12, 5, 3, 1, 0, 0, LOC)
-	e = &Event{T: eventTime, Cats: []string{"1", "2"}}
+	e = &Event{T: eventTime, Cats: Categories.IDs([]string{"1", "2"})}
 	e.setCurrent(startTime, endTime)
 	if e.Current {
 		t.Error("event is not current")
 	}
 }
+
+func TestEventHistDST(t *testing.T) {
+	//1am happens twice on 2016-11-06 in New York, the file holds the first one
+	x := &FileTimeExtractor{Pattern: FileTimes.Pattern, Layout: DefaultTimeLayout, Location: LOC}
+	ti, err := x.Extract("artefact_2016110601.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2016, 11, 6, 5, 30, 0, 0, time.UTC)
+	second := time.Date(2016, 11, 6, 6, 30, 0, 0, time.UTC)
+	if (Event{T: first}).Hist(&ti) {
+		t.Error("event in the first 1am is in the hour of the file")
+	}
+	if !(Event{T: second}).Hist(&ti) {
+		t.Error("event in the repeated 1am is not in the hour of the file")
+	}
+
+	sess, _, err := getSession(TSV, []byte(fmt.Sprint("cookie\t", first.Unix(), ":1;", second.Unix(), ":2")), &ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sess.String()
+	if strings.Count(s, "Nov  6 01:30:00") != 2 {
+		t.Error("events are not printed in the timezone of the dataset", s)
+	}
+
+	x.Location = time.UTC
+	ti, _ = x.Extract("artefact_2016110606.log")
+	if (Event{T: second}).Hist(&ti) || !(Event{T: first}).Hist(&ti) {
+		t.Error("wrong hour in UTC")
+	}
+}
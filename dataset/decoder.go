@@ -0,0 +1,180 @@
+package cookieDb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+//LineDecoder turns a raw line of input into a cookie id and its events
+type LineDecoder interface {
+	//ID returns only the cookie id of line, the events are not looked at
+	ID(line []byte) (string, error)
+	//Decode returns the cookie id of line and its events, Event.His is left for the Shard to set.
+	//The built in decoders leave Event.Cats to the Shard too, it looks the categories up once it accepts the line.
+	Decode(line []byte) (string, []Event, error)
+}
+
+var (
+	//TSV decodes the native "cookieID\ttimestamp:cat,cat;timestamp:cat" format
+	TSV LineDecoder = tsvDecoder{}
+	//JSONLines decodes {"cookie": "id", "events": [{"ts": 1480551255, "cats": ["3", "17"]}]} objects, one per line
+	JSONLines LineDecoder = jsonDecoder{}
+	//CSV decodes "cookieID,timestamp,cats" records where cats is a quoted comma separated list,
+	//more events follow as extra timestamp and cats columns
+	CSV LineDecoder = csvDecoder{}
+)
+
+var decoders = map[string]LineDecoder{
+	"tsv":   TSV,
+	"jsonl": JSONLines,
+	"json":  JSONLines,
+	"csv":   CSV,
+}
+
+//RegisterDecoder makes DecoderFor pick dec for files with the extension ext, it is not safe to call while ingesting
+func RegisterDecoder(ext string, dec LineDecoder) {
+	decoders[strings.TrimPrefix(ext, ".")] = dec
+}
+
+//DecoderFor returns the decoder for the input file name by looking at its extensions, so that
+//foo_2016111100.jsonl.gz and its shard foo_2016111100.jsonl.gz.StatSet.gob are both JSON Lines.
+//Files without a known extension are TSV.
+func DecoderFor(fileName string) LineDecoder {
+	exts := strings.Split(filepath.Base(fileName), ".")
+	for i := len(exts) - 1; i > 0; i-- {
+		if dec, ok := decoders[exts[i]]; ok {
+			return dec
+		}
+	}
+	return TSV
+}
+
+type tsvDecoder struct{}
+
+func (tsvDecoder) ID(line []byte) (string, error) {
+	id, _, err := getFields(line)
+	return id, err
+}
+
+func (tsvDecoder) Decode(line []byte) (string, []Event, error) {
+	id, fields, err := getFields(line)
+	if err != nil {
+		return "", nil, err
+	}
+	events, err := getEvents(fields)
+	return id, events, err
+}
+
+type jsonDecoder struct{}
+
+type jsonLine struct {
+	Cookie string `json:"cookie"`
+	Events []struct {
+		TS   json.RawMessage   `json:"ts"`
+		Cats []json.RawMessage `json:"cats"`
+	} `json:"events"`
+}
+
+//unquote returns the contents of a json string, other values such as numbers are returned as they are
+func unquote(raw json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	return bytes.TrimSpace(raw)
+}
+
+func (jsonDecoder) ID(line []byte) (string, error) {
+	var l struct {
+		Cookie string `json:"cookie"`
+	}
+	if err := json.Unmarshal(line, &l); err != nil {
+		return "", newParseError(ReasonBadRecord, err)
+	}
+	if l.Cookie == "" {
+		return "", newParseError(ReasonEmptyCookieID, errors.New("record has no cookie id"))
+	}
+	return l.Cookie, nil
+}
+
+func (jsonDecoder) Decode(line []byte) (string, []Event, error) {
+	var l jsonLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return "", nil, newParseError(ReasonBadRecord, err)
+	}
+	if l.Cookie == "" {
+		return "", nil, newParseError(ReasonEmptyCookieID, errors.New("record has no cookie id"))
+	}
+	if len(l.Events) == 0 {
+		return "", nil, newParseError(ReasonMissingFields, errors.New("record has no events"))
+	}
+	events := make([]Event, 0, len(l.Events))
+	for _, raw := range l.Events {
+		if len(raw.TS) == 0 {
+			return "", nil, newParseError(ReasonBadTimestamp, errors.New("event has no ts"))
+		}
+		if len(raw.Cats) == 0 {
+			return "", nil, newParseError(ReasonMissingCategories, errors.New("event has no cats"))
+		}
+		t, err := parseStamp(unquote(raw.TS))
+		if err != nil {
+			return "", nil, err
+		}
+		e := Event{T: t}
+		for _, cat := range raw.Cats {
+			e.names = append(e.names, string(unquote(cat)))
+		}
+		events = append(events, e)
+	}
+	return l.Cookie, events, nil
+}
+
+type csvDecoder struct{}
+
+func readRecord(line []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(line))
+	r.FieldsPerRecord = -1
+	record, err := r.Read()
+	if err != nil {
+		return nil, newParseError(ReasonBadRecord, err)
+	}
+	if record[0] == "" {
+		return nil, newParseError(ReasonEmptyCookieID, errors.New("record has an empty cookie id"))
+	}
+	return record, nil
+}
+
+func (csvDecoder) ID(line []byte) (string, error) {
+	record, err := readRecord(line)
+	if err != nil {
+		return "", err
+	}
+	return record[0], nil
+}
+
+func (csvDecoder) Decode(line []byte) (string, []Event, error) {
+	record, err := readRecord(line)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(record) == 1 {
+		return "", nil, newParseError(ReasonMissingFields, errors.New("record has no events"))
+	}
+	if len(record)%2 == 0 {
+		return "", nil, newParseError(ReasonMissingCategories, fmt.Errorf("record has %d columns, the last event has no categories", len(record)))
+	}
+	var events []Event
+	for i := 1; i < len(record); i += 2 {
+		t, err := parseStamp([]byte(record[i]))
+		if err != nil {
+			return "", nil, err
+		}
+		events = append(events, Event{T: t, names: getCats([]byte(record[i+1]))})
+	}
+	return record[0], events, nil
+}
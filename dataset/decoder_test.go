@@ -0,0 +1,84 @@
+package cookieDb
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoderFor(t *testing.T) {
+	testCases := []struct {
+		name string
+		dec  LineDecoder
+	}{
+		{"foo_2016111100.log", TSV},
+		{"foo_2016111100.log.gz", TSV},
+		{"partner_2016111100.jsonl", JSONLines},
+		{"partner_2016111100.jsonl.gz.StatSet.gob", JSONLines},
+		{"dir.csv/partner_2016111100.csv.bz2", CSV},
+	}
+	for _, test := range testCases {
+		if got := DecoderFor(test.name); got != test.dec {
+			t.Errorf("%s: got %T, expected %T", test.name, got, test.dec)
+		}
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	testCases := []struct {
+		dec   LineDecoder
+		lines string
+	}{
+		{TSV, "cookieA\t1478840400:3,17;1478840460:4\ncookieB\t1478840401:5"},
+		{JSONLines, `{"cookie": "cookieA", "events": [{"ts": 1478840400, "cats": ["3", "17"]}, {"ts": "1478840460", "cats": [4]}]}
+{"cookie": "cookieB", "events": [{"ts": 1478840401, "cats": ["5"]}]}`},
+		{CSV, "cookieA,1478840400,\"3,17\",1478840460,4\ncookieB,1478840401,5"},
+	}
+	var expected *User
+	for _, test := range testCases {
+		id, events, err := test.dec.Decode([]byte(strings.Split(test.lines, "\n")[0]))
+		if err != nil {
+			t.Fatalf("%T: %v", test.dec, err)
+		}
+		if id != "cookieA" || len(events) != 2 || !reflect.DeepEqual(events[0].names, []string{"3", "17"}) || events[1].T.Unix() != 1478840460 {
+			t.Errorf("%T: wrong decode %s %v", test.dec, id, events)
+		}
+
+		RegisterDecoder("test", test.dec)
+		d := make(StatSet)
+		if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(test.lines)), &d, "feed_2016111100.test", &FillOptions{Policy: AbortOnBadLine}); err != nil {
+			t.Fatalf("%T: %v", test.dec, err)
+		}
+		u := d.Get("cookieA").User()
+		if expected == nil {
+			expected = u
+		} else if !reflect.DeepEqual(u, expected) {
+			t.Errorf("%T: got %v, expected %v", test.dec, u, expected)
+		}
+	}
+	delete(decoders, "test")
+}
+
+func TestDecoderErrors(t *testing.T) {
+	testCases := []struct {
+		dec    LineDecoder
+		line   string
+		reason Reason
+	}{
+		{JSONLines, `{"cookie": "a", "events": [`, ReasonBadRecord},
+		{JSONLines, `{"events": [{"ts": 1, "cats": ["3"]}]}`, ReasonEmptyCookieID},
+		{JSONLines, `{"cookie": "a", "events": [{"ts": "soon", "cats": ["3"]}]}`, ReasonBadTimestamp},
+		{JSONLines, `{"cookie": "a", "events": [{"ts": 1}]}`, ReasonMissingCategories},
+		{CSV, `a,"1`, ReasonBadRecord},
+		{CSV, `a`, ReasonMissingFields},
+		{CSV, `a,1478840400`, ReasonMissingCategories},
+	}
+	for _, test := range testCases {
+		_, _, err := test.dec.Decode([]byte(test.line))
+		perr, ok := err.(*ParseError)
+		if !ok || perr.Reason != test.reason {
+			t.Errorf("%T %q: expected %s, got %v", test.dec, test.line, test.reason, err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package cookieDb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//CatID is the compact id a Dict gives to a category
+type CatID uint32
+
+//Dict maps the categories of a store to CatIDs, ids are handed out in order and never change.
+//The dictionary of a store is shared by every process that writes to the store: a new category is given its id
+//while the dictionary file is locked, after the categories other processes added are read, and it is appended
+//to the file before the id is used. Two processes can not give one id to different categories.
+type Dict struct {
+	//Path is where new categories are appended, nothing is written when it is empty
+	Path string
+
+	mu    sync.RWMutex
+	ids   map[string]CatID
+	names []string
+	//size is how much of the file at Path has been read
+	size int64
+	//err is the first error writing to Path, the ids handed out since are not saved
+	err error
+}
+
+//NewDict returns an empty dictionary that is saved to path
+func NewDict(path string) *Dict {
+	return &Dict{Path: path, ids: make(map[string]CatID)}
+}
+
+//DictPath returns the file in the store dir that holds its dictionary
+func DictPath(dir string) string {
+	return filepath.Join(dir, "categories.dict")
+}
+
+//StoreDirOf returns the dir of the store shardName is in, the nearest dir up from it that has a dictionary
+//or a catalog. A shard outside of any store is in the store of its own dir.
+func StoreDirOf(shardName string) string {
+	dir := filepath.Dir(shardName)
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(DictPath(d)); err == nil {
+			return d
+		}
+		if _, err := os.Stat(CatalogPath(d)); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+//Categories is the dictionary the Shards encode and decode categories with
+var Categories = NewDict("")
+
+//LoadDict reads the dictionary saved at path, a missing file gives an empty dictionary.
+//The file is a sequence of gob encoded lists of categories, each appended by one call to ID or IDs.
+func LoadDict(path string) (*Dict, error) {
+	d := NewDict(path)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := d.read(f); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+//read adds the categories appended to f since it was last read. A list cut off by a crash while it was
+//appended ends the file, the next append overwrites it.
+func (d *Dict) read(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= d.size {
+		return nil
+	}
+	data := make([]byte, info.Size()-d.size)
+	if _, err := f.ReadAt(data, d.size); err != nil {
+		return err
+	}
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		//every list is a gob stream of its own, a bytes.Reader is not read ahead of the list
+		var names []string
+		if err := gob.NewDecoder(r).Decode(&names); err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", d.Path, err)
+		}
+		for _, name := range names {
+			if _, ok := d.ids[name]; !ok {
+				d.ids[name] = CatID(len(d.names))
+				d.names = append(d.names, name)
+			}
+		}
+		d.size = info.Size() - int64(r.Len())
+	}
+	return nil
+}
+
+//ID returns the id of the category name, adding it when it is new
+func (d *Dict) ID(name string) CatID {
+	return d.IDs([]string{name})[0]
+}
+
+//IDs returns the ids of names, the new ones are added at once
+func (d *Dict) IDs(names []string) []CatID {
+	ids := make([]CatID, 0, len(names))
+	d.mu.RLock()
+	for _, name := range names {
+		id, ok := d.ids[name]
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	d.mu.RUnlock()
+	if len(ids) == len(names) {
+		return ids
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var added []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := d.ids[name]; !ok && !seen[name] {
+			added = append(added, name)
+			seen[name] = true
+		}
+	}
+	if len(added) > 0 {
+		d.add(added)
+	}
+	ids = ids[:0]
+	for _, name := range names {
+		ids = append(ids, d.ids[name])
+	}
+	return ids
+}
+
+//add gives ids to the new categories names. With a Path they are appended to the dictionary file under a lock,
+//after the categories other processes appended are read. Names another process added meanwhile keep their id.
+//A failed write is kept in err and returned by Save, the ids are still handed out.
+func (d *Dict) add(names []string) {
+	if d.Path != "" && d.err == nil {
+		d.err = d.append(names)
+	}
+	for _, name := range names {
+		if _, ok := d.ids[name]; !ok {
+			d.ids[name] = CatID(len(d.names))
+			d.names = append(d.names, name)
+		}
+	}
+}
+
+func (d *Dict) append(names []string) error {
+	f, err := os.OpenFile(d.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	created := d.size == 0
+	if err := d.read(f); err != nil {
+		return err
+	}
+	var added []string
+	for _, name := range names {
+		if _, ok := d.ids[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(added); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf.Bytes(), d.size); err != nil {
+		return err
+	}
+	if err := f.Truncate(d.size + int64(buf.Len())); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if created {
+		syncDir(filepath.Dir(d.Path))
+	}
+	for _, name := range added {
+		d.ids[name] = CatID(len(d.names))
+		d.names = append(d.names, name)
+	}
+	d.size += int64(buf.Len())
+	return f.Close()
+}
+
+//Name returns the category of id, or "" for an id the dictionary does not know
+func (d *Dict) Name(id CatID) string {
+	return d.Names([]CatID{id})[0]
+}
+
+//Names returns the categories of ids, "" for an id the dictionary does not know. Ids that are not known are
+//looked up again in the file at Path, another process can have added them since it was read.
+func (d *Dict) Names(ids []CatID) []string {
+	d.mu.RLock()
+	names, ok := d.lookup(ids)
+	d.mu.RUnlock()
+	if ok || d.Path == "" {
+		return names
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, err := os.Open(d.Path); err == nil {
+		d.read(f)
+		f.Close()
+	}
+	names, _ = d.lookup(ids)
+	return names
+}
+
+//lookup returns the categories of ids, and if every id was known
+func (d *Dict) lookup(ids []CatID) ([]string, bool) {
+	names := make([]string, 0, len(ids))
+	known := true
+	for _, id := range ids {
+		if int(id) < len(d.names) {
+			names = append(names, d.names[id])
+		} else {
+			names = append(names, "")
+			known = false
+		}
+	}
+	return names, known
+}
+
+//Len returns the number of categories in the dictionary
+func (d *Dict) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.names)
+}
+
+//Save returns the error of the first failed write to the dictionary file. Categories are appended to the file
+//when they get their id, a shard written after Save succeeded only holds ids that can be decoded.
+func (d *Dict) Save() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.err
+}
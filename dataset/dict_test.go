@@ -0,0 +1,119 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	path := DictPath(t.TempDir())
+	d, err := LoadDict(path)
+	if err != nil || d.Len() != 0 {
+		t.Fatal("missing dictionary is not empty", err)
+	}
+	ids := d.IDs([]string{"3", "17", "3"})
+	if ids[0] != ids[2] || ids[0] == ids[1] {
+		t.Error("wrong ids", ids)
+	}
+	if err := d.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadDict(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Names(ids), []string{"3", "17", "3"}) || loaded.ID("17") != ids[1] {
+		t.Error("dictionary does not round trip", loaded.Names(ids))
+	}
+	if loaded.ID("218") != 2 || loaded.Name(7) != "" {
+		t.Error("wrong id for a new category")
+	}
+}
+
+func TestDictShared(t *testing.T) {
+	path := DictPath(t.TempDir())
+	a, _ := LoadDict(path)
+	b, _ := LoadDict(path)
+	a.ID("3")
+	b.ID("17")
+	b.ID("3")
+	if a.ID("3") != b.ID("3") || a.Name(b.ID("17")) != "17" || a.ID("218") == b.ID("17") {
+		t.Error("dictionaries of one store disagree", a.Names([]CatID{0, 1, 2}), b.Names([]CatID{0, 1, 2}))
+	}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	//a list cut off while it was appended is overwritten by the next one
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0x20, 0xff})
+	f.Close()
+	c, err := LoadDict(path)
+	if err != nil || c.Len() != 3 {
+		t.Fatal("torn dictionary does not load", err)
+	}
+	c.ID("25222")
+	if loaded, err := LoadDict(path); err != nil || loaded.Name(3) != "25222" {
+		t.Error("append after a torn list is lost", err)
+	}
+}
+
+func TestCategoriesInShard(t *testing.T) {
+	dir := t.TempDir()
+	saved := Categories
+	defer func() { Categories = saved }()
+	Categories = NewDict(DictPath(dir))
+
+	f, err := os.Open("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := make(CountTimeCatsSet)
+	if _, _, err := FillDb(bufio.NewScanner(f), &d, "test_2016111100.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(dir+"/test.gob", &d); err != nil {
+		t.Fatal(err)
+	}
+	Categories, err = LoadDict(DictPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadShard(dir + "/test.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cats := s.Get("m2uszQDo999wwSBU").Cats()
+	if strings.Join(cats, ",") != "17,218,25222,3" {
+		t.Error("categories are not decoded", cats)
+	}
+}
+
+func TestCategoriesOfRejectedLines(t *testing.T) {
+	dir := t.TempDir()
+	saved := Categories
+	defer func() { Categories = saved }()
+	Categories = NewDict(DictPath(dir))
+
+	lines := "a\t1478840400:rejected1;soon:rejected2\nb\t1478840400:kept\n"
+	name := dir + "/feed.log"
+	if err := os.WriteFile(name, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := earliestEvent(name); err != nil {
+		t.Fatal(err)
+	}
+	if Categories.Len() != 0 {
+		t.Error("guessing the time of a file added categories", Categories.Len())
+	}
+	d := make(CountTimeCatsSet)
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(lines)), &d, "feed_2016111100.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	if Categories.Len() != 1 || Categories.Name(0) != "kept" {
+		t.Error("categories of a rejected line were added", Categories.Len())
+	}
+}
@@ -0,0 +1,279 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//ErasureRecord is the audit record of the erasure of one cookie, it holds a hash of the id instead of the id
+type ErasureRecord struct {
+	IDHash string    `json:"idSha256"`
+	Time   time.Time `json:"time"`
+	//Shards are the files of the store the cookie was removed from
+	Shards []string `json:"shards"`
+}
+
+//AuditPath returns the append only file in the store dir that holds the ErasureRecords
+func AuditPath(dir string) string {
+	return filepath.Join(dir, "erasure.log")
+}
+
+//Erase removes the cookies ids from every shard file in the dir of the store, cataloged or not, from its partitions
+//and from the rejected lines kept next to its shards. Every file that held one of them is rewritten in one rename.
+//The store lock is held exclusively meanwhile, so no other process writes a shard that Erase misses.
+//A record of every id is appended to the audit log at AuditPath, also for ids that were not found and when Erase
+//fails part way. The logs are not touched, shards rebuilt from them leave the ids out when they are filled with the
+//ErasedIDs of the store in their FillOptions. Shards outside the store, like the output of CompactShards
+//written elsewhere, are not erased.
+func (s *Store) Erase(ids []string, now time.Time) ([]ErasureRecord, error) {
+	if err := flockFile(s.lock, true, true); err != nil {
+		return nil, err
+	}
+	defer flockFile(s.lock, false, true)
+	touched := make(map[string][]string)
+	err := s.load()
+	if err == nil {
+		err = s.erase(ids, touched)
+	}
+	records := make([]ErasureRecord, len(ids))
+	for i, id := range ids {
+		records[i] = ErasureRecord{IDHash: hashID(id), Time: now, Shards: touched[id]}
+	}
+	if aerr := appendAudit(AuditPath(s.Dir), records); err == nil {
+		err = aerr
+	}
+	return records, err
+}
+
+//ErasedIDs holds the hashes of the cookie ids erased from a store, as they are recorded in its audit log
+type ErasedIDs map[string]bool
+
+//LoadErased reads the ErasedIDs of the store in dir from its audit log, a missing log erased nothing
+func LoadErased(dir string) (ErasedIDs, error) {
+	f, err := os.Open(AuditPath(dir))
+	if os.IsNotExist(err) {
+		return ErasedIDs{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	erased := make(ErasedIDs)
+	for dec := json.NewDecoder(f); dec.More(); {
+		var r ErasureRecord
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("%s: %v", AuditPath(dir), err)
+		}
+		erased[r.IDHash] = true
+	}
+	return erased, nil
+}
+
+//Has tells if the cookie id was erased
+func (e ErasedIDs) Has(id string) bool {
+	return e[hashID(id)]
+}
+
+//hashID returns the hash of a cookie id that ErasureRecords hold
+func hashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+//erase removes ids from the files under the dir of the store and lists the files each id was in, by their path in it
+func (s *Store) erase(ids []string, touched map[string][]string) error {
+	p, err := OpenPartitions(s.PartitionsDir())
+	if os.IsNotExist(err) {
+		p = nil
+	} else if err != nil {
+		return err
+	}
+	parts := make(map[string]int)
+	if p != nil {
+		for i := range p.Parts {
+			parts[p.Path(i)] = i
+		}
+	}
+	partsChanged := false
+	err = filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".gob":
+			d, erased, err := eraseShard(path, ids)
+			if err != nil {
+				return fmt.Errorf("%s: %v", rel, err)
+			}
+			if len(erased) == 0 {
+				return nil
+			}
+			for _, id := range erased {
+				touched[id] = append(touched[id], rel)
+			}
+			if s.Has(path) {
+				s.mu.Lock()
+				e := s.catalog[info.Name()]
+				s.mu.Unlock()
+				if _, err := s.update(e); err != nil {
+					return err
+				}
+			}
+			if _, err := os.Stat(TimeIndexPath(path)); err == nil {
+				if err := writeTimeIndex(path, newTimeIndex(d)); err != nil {
+					return err
+				}
+			}
+			if i, ok := parts[path]; ok {
+				p.Parts[i].Count = d.Size()
+				partsChanged = true
+			}
+		case ".rejected":
+			erased, err := eraseLines(path, ids)
+			if err != nil {
+				return err
+			}
+			for _, id := range erased {
+				touched[id] = append(touched[id], rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if partsChanged {
+		return p.save()
+	}
+	return nil
+}
+
+//eraseShard removes the cookies ids from the shard file shardName and rewrites it in the format it was in.
+//It returns the rewritten shard and the ids it held, the file is not touched when it held none of them.
+//Every shard is read, its Bloom filter is not trusted to be up to date with it.
+func eraseShard(shardName string, ids []string) (Shard, []string, error) {
+	d, h, err := readShard(shardName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var erased []string
+	for _, id := range ids {
+		if d.Get(id) != nil {
+			erased = append(erased, id)
+		}
+	}
+	write := writeShard
+	if ro, ok := d.(readOnlyShard); ok {
+		if len(erased) > 0 {
+			d, err = ro.Load()
+		}
+		ro.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		write = writeIndexedShard
+	}
+	if len(erased) == 0 {
+		return nil, nil, nil
+	}
+	if err := removeCookies(d, erased); err != nil {
+		return nil, nil, err
+	}
+	return d, erased, replaceShard(shardName, d, func(tmp string) error { return write(tmp, d, h.rewrite(d)) })
+}
+
+//removeCookies deletes the cookies ids from d
+func removeCookies(d Shard, ids []string) error {
+	for _, id := range ids {
+		switch set := d.(type) {
+		case *Intersection:
+			delete(*set, id)
+		case *CountTimeSet:
+			delete(*set, id)
+		case *CountTimeCatsSet:
+			delete(*set, id)
+		case *StatSet:
+			delete(*set, id)
+		default:
+			return fmt.Errorf("cookies can not be removed from a %s", d.Type())
+		}
+	}
+	return nil
+}
+
+//eraseLines removes the lines of the cookies ids from the file path, which holds lines of the input format of its
+//name, and rewrites it in one rename. The id of a line that does not decode is its first field.
+//It returns the ids it found.
+func eraseLines(path string, ids []string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	erase := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		erase[id] = true
+	}
+	dec := DecoderFor(path)
+	found := make(map[string]bool)
+	var kept bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		id, err := dec.ID(bytes.TrimRight(line, "\r\n"))
+		if err != nil {
+			if fields := bytes.Fields(line); len(fields) > 0 {
+				id = string(fields[0])
+			}
+		}
+		if erase[id] {
+			found[id] = true
+		} else {
+			kept.Write(line)
+		}
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	if err := replaceFile(path, kept.Bytes()); err != nil {
+		return nil, err
+	}
+	var erased []string
+	for _, id := range ids {
+		if found[id] {
+			erased = append(erased, id)
+		}
+	}
+	return erased, nil
+}
+
+//appendAudit appends records to the audit log at path, one json object per line, and syncs it to disk
+func appendAudit(path string, records []ErasureRecord) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
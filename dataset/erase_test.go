@@ -0,0 +1,131 @@
+package cookieDb
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreErase(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := dir + "/foo_2016111100.log"
+	input := LINE + "\nkeep\t1478840501:4"
+	var names []string
+	for i, d := range []Shard{&Intersection{}, &CountTimeSet{}, &CountTimeCatsSet{}, &StatSet{}} {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), d, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, i%2 == 1); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s.Path(source, d.Type()))
+	}
+	if _, err := PartitionShards(s.PartitionsDir(), 2, names[3:], false); err != nil {
+		t.Fatal(err)
+	}
+	rejected := QuarantinePath(names[0])
+	os.WriteFile(rejected, []byte("BhrVPRR199e9aC8R\tbroken\nother\tbroken\nxBhrVPRR199e9aC8R\tbroken\nBhrVPRR199e9aC8R broken\n"), 0644)
+	if err := writeBloom(names[1], &CountTimeSet{}); err != nil {
+		t.Fatal(err)
+	}
+
+	//a shard that is not cataloged yet, like the one Follow writes until its hour ends
+	uncataloged := dir + "/foo_2016111101.log.StatSet.gob"
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), &StatSet{}, uncataloged, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(uncataloged, d); err != nil {
+		t.Fatal(err)
+	}
+
+	//erasing waits for the other processes that have the store open
+	writer, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []ErasureRecord
+	done := make(chan error)
+	go func() {
+		var err error
+		records, err = s.Erase([]string{"BhrVPRR199e9aC8R", "unknown"}, time.Now())
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("erase did not wait for the store to be closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	writer.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if d, err := ReadShard(uncataloged); err != nil || d.Get("BhrVPRR199e9aC8R") != nil || d.Get("keep") == nil {
+		t.Error("cookie was not erased from a shard that is not cataloged", err)
+	}
+	if len(records) != 2 || len(records[0].Shards) != 7 || len(records[1].Shards) != 0 {
+		t.Error("wrong records", records)
+	}
+	if strings.Contains(records[0].IDHash, "BhrVPRR199e9aC8R") || len(records[0].IDHash) != 64 {
+		t.Error("record holds no hash of the id", records[0].IDHash)
+	}
+	for _, e := range s.List("") {
+		d, err := s.Open(e.Source, e.Type)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Get("BhrVPRR199e9aC8R") != nil || d.Get("keep") == nil || e.Count != 1 {
+			t.Error(e.Type, "cookie was not erased or too much was", e.Count)
+		}
+	}
+	p, err := OpenPartitions(s.PartitionsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.Get("BhrVPRR199e9aC8R"); err != nil || c != nil {
+		t.Error("cookie was not erased from its partition", err)
+	}
+	if data, _ := os.ReadFile(rejected); string(data) != "other\tbroken\nxBhrVPRR199e9aC8R\tbroken\n" {
+		t.Error("rejected lines still hold the cookie", string(data))
+	}
+
+	f, err := os.Open(AuditPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var logged []ErasureRecord
+	for dec := json.NewDecoder(f); dec.More(); {
+		var r ErasureRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		logged = append(logged, r)
+	}
+	if len(logged) != 2 || logged[0].IDHash != records[0].IDHash {
+		t.Error("wrong audit log", logged)
+	}
+
+	erased, err := LoadErased(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !erased.Has("BhrVPRR199e9aC8R") || !erased.Has("unknown") || erased.Has("keep") {
+		t.Error("wrong erased ids", erased)
+	}
+	d, report, err := FillDb(bufio.NewScanner(strings.NewReader(input)), &StatSet{}, source, &FillOptions{Erased: erased})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Get("BhrVPRR199e9aC8R") != nil || d.Get("keep") == nil || report.Erased != 1 {
+		t.Error("rebuilt shard brings back an erased cookie", report)
+	}
+}
@@ -0,0 +1,165 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Fallback decides where FileTimeExtractor gets the time of a file whose name holds none
+type Fallback int
+
+const (
+	//NoFallback makes Extract return an error
+	NoFallback Fallback = iota
+	//ModTimeFallback uses the modification time of the file, truncated to the hour
+	ModTimeFallback
+	//EarliestEventFallback reads the file and uses its earliest event timestamp, truncated to the hour
+	EarliestEventFallback
+)
+
+var fallbackNames = map[Fallback]string{
+	NoFallback:            "none",
+	ModTimeFallback:       "mtime",
+	EarliestEventFallback: "events",
+}
+
+func (f Fallback) String() string {
+	if name, ok := fallbackNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Fallback(%d)", int(f))
+}
+
+//ParseFallback returns the fallback named by s, one of none, mtime or events
+func ParseFallback(s string) (Fallback, error) {
+	for f, name := range fallbackNames {
+		if strings.EqualFold(s, name) {
+			return f, nil
+		}
+	}
+	return NoFallback, fmt.Errorf("unknown file time fallback %q", s)
+}
+
+//DefaultTimePattern matches the hour in names like eu-west_clicks_2016111100.log
+const DefaultTimePattern = `_(\d{10})(?:\.|$)`
+
+//DefaultTimeLayout is the layout of the hour matched by DefaultTimePattern
+const DefaultTimeLayout = "2006010215"
+
+//FileTimeExtractor finds the hour a log file holds. The first submatch of Pattern in the base name of the file,
+//or the whole match when Pattern has no groups, is parsed with Layout in Location. Times are remembered per file name.
+type FileTimeExtractor struct {
+	Pattern  *regexp.Regexp
+	Layout   string
+	Fallback Fallback
+	//Location is the timezone of the dataset, LOC when nil
+	Location *time.Location
+
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+//NewFileTimeExtractor compiles pattern into a FileTimeExtractor
+func NewFileTimeExtractor(pattern, layout string, fallback Fallback) (*FileTimeExtractor, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTimeExtractor{Pattern: re, Layout: layout, Fallback: fallback}, nil
+}
+
+//FileTimes is the extractor used by the Shards to find the time of the file they are filled from
+var FileTimes = &FileTimeExtractor{Pattern: regexp.MustCompile(DefaultTimePattern), Layout: DefaultTimeLayout}
+
+//Loc returns the timezone of the dataset
+func (x *FileTimeExtractor) Loc() *time.Location {
+	if x.Location == nil {
+		return LOC
+	}
+	return x.Location
+}
+
+//Remember makes Extract return t for fileName, e.g. for a shard that is filled from a file whose time is already known
+func (x *FileTimeExtractor) Remember(fileName string, t time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.times == nil {
+		x.times = make(map[string]time.Time)
+	}
+	x.times[fileName] = t
+}
+
+//Extract returns the time of fileName
+func (x *FileTimeExtractor) Extract(fileName string) (time.Time, error) {
+	x.mu.Lock()
+	t, ok := x.times[fileName]
+	x.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+	t, err := x.extract(fileName)
+	if err != nil {
+		return t, err
+	}
+	x.Remember(fileName, t)
+	return t, nil
+}
+
+func (x *FileTimeExtractor) extract(fileName string) (time.Time, error) {
+	m := x.Pattern.FindStringSubmatch(filepath.Base(fileName))
+	if m != nil {
+		s := m[0]
+		if len(m) > 1 {
+			s = m[1]
+		}
+		return time.ParseInLocation(x.Layout, s, x.Loc())
+	}
+	switch x.Fallback {
+	case ModTimeFallback:
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime().Truncate(time.Hour).In(x.Loc()), nil
+	case EarliestEventFallback:
+		t, err := earliestEvent(fileName)
+		return t.In(x.Loc()), err
+	}
+	return time.Time{}, fmt.Errorf("no time matching %s in file name %s", x.Pattern, fileName)
+}
+
+//earliestEvent returns the hour of the earliest event in the log file name, lines that do not decode are ignored
+func earliestEvent(name string) (time.Time, error) {
+	f, err := OpenInput(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	dec := DecoderFor(name)
+	var earliest time.Time
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		_, events, err := dec.Decode(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		for _, e := range events {
+			if earliest.IsZero() || e.T.Before(earliest) {
+				earliest = e.T
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	if earliest.IsZero() {
+		return earliest, fmt.Errorf("%s has no events to take its time from", name)
+	}
+	return earliest.Truncate(time.Hour), nil
+}
@@ -0,0 +1,61 @@
+package cookieDb
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileTimeExtractor(t *testing.T) {
+	expected := time.Date(2016, 11, 11, 0, 0, 0, 0, LOC)
+	x, err := NewFileTimeExtractor(DefaultTimePattern, DefaultTimeLayout, NoFallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"eu-west_clicks_2016111100.log", "/logs/eu_west/foo_2016111100.log.gz.StatSet.gob", "foo_2016111100"} {
+		if got, err := x.Extract(name); err != nil || !got.Equal(expected) {
+			t.Error(name, got, err)
+		}
+	}
+	if _, err := x.Extract("clicks.log"); err == nil {
+		t.Error("expected an error for a name without a time")
+	}
+
+	x, err = NewFileTimeExtractor(`clicks-(\d{4}-\d{2}-\d{2}T\d{2})`, "2006-01-02T15", NoFallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := x.Extract("clicks-2016-11-11T00.log"); err != nil || !got.Equal(expected) {
+		t.Error("custom layout", got, err)
+	}
+	if _, err := NewFileTimeExtractor(`(`, DefaultTimeLayout, NoFallback); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
+
+func TestFileTimeFallback(t *testing.T) {
+	dir := t.TempDir()
+	name := dir + "/clicks.log"
+	if err := os.WriteFile(name, []byte("broken\ncookieA\t1478844000:3;1478840461:4\ncookieB\t1478843000:3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2016, 11, 11, 5, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := NewFileTimeExtractor(DefaultTimePattern, DefaultTimeLayout, ModTimeFallback)
+	if got, err := x.Extract(name); err != nil || !got.Equal(mtime.Truncate(time.Hour)) {
+		t.Error("mtime fallback", got, err)
+	}
+
+	x, _ = NewFileTimeExtractor(DefaultTimePattern, DefaultTimeLayout, EarliestEventFallback)
+	if got, err := x.Extract(name); err != nil || !got.Equal(time.Unix(1478840400, 0)) {
+		t.Error("earliest event fallback", got, err)
+	}
+
+	x.Remember("shard.gob", mtime)
+	if got, err := x.Extract("shard.gob"); err != nil || !got.Equal(mtime) {
+		t.Error("remembered time", got, err)
+	}
+}
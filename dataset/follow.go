@@ -0,0 +1,112 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+//FollowOptions configures Follow, zero durations fall back to the defaults below
+type FollowOptions struct {
+	FillOptions
+	//Poll is how long Follow waits for new lines once it has read everything
+	Poll time.Duration
+	//Checkpoint is how often the shard is written while the file is being followed
+	Checkpoint time.Duration
+	//Grace is how long after the end of the hour late lines are still waited for
+	Grace time.Duration
+	//Now returns the current time, it is time.Now when nil
+	Now func() time.Time
+}
+
+const (
+	defaultPoll       = time.Second
+	defaultCheckpoint = time.Minute
+	defaultGrace      = time.Minute
+)
+
+func (o *FollowOptions) withDefaults() *FollowOptions {
+	opts := *o
+	if opts.Poll <= 0 {
+		opts.Poll = defaultPoll
+	}
+	if opts.Checkpoint <= 0 {
+		opts.Checkpoint = defaultCheckpoint
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = defaultGrace
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &opts
+}
+
+//Follow adds the lines of the log file name to d as they are appended to it, writing d to shardName every
+//Checkpoint. It returns once the hour in the file name is over and everything written to the file has been read,
+//after writing the shard one last time. The hour is found by FileTimes. Compressed files can not be followed.
+func Follow(name string, d Shard, shardName string, opts *FollowOptions) (Shard, *Report, error) {
+	if opts == nil {
+		opts = &FollowOptions{}
+	}
+	opts = opts.withDefaults()
+	fillOpts, err := checkFillOptions(&opts.FillOptions)
+	if err != nil {
+		return d, nil, err
+	}
+	start, err := FileTimes.Extract(name)
+	if err != nil {
+		return d, nil, err
+	}
+	FileTimes.Remember(shardName, start)
+	end := start.Add(time.Hour + opts.Grace)
+	f, err := os.Open(name)
+	if err != nil {
+		return d, nil, err
+	}
+	defer f.Close()
+	report := &Report{File: shardName}
+	r := bufio.NewReader(f)
+	var partial []byte
+	var offset int64
+	lastCheckpoint := opts.Now()
+	draining := false
+	for {
+		chunk, err := r.ReadBytes('\n')
+		partial = append(partial, chunk...)
+		if err == nil {
+			line := bytes.TrimSuffix(bytes.TrimSuffix(partial, []byte("\n")), []byte("\r"))
+			if err := report.add(d, line, shardName, offset, fillOpts); err != nil {
+				return d, report, err
+			}
+			offset += int64(len(partial))
+			partial = partial[:0]
+		} else if err != io.EOF {
+			return d, report, err
+		} else if draining {
+			break
+		} else if !opts.Now().Before(end) {
+			//lines appended before the hour was found to be over are read before finishing
+			draining = true
+			continue
+		} else {
+			time.Sleep(opts.Poll)
+		}
+		if now := opts.Now(); now.Sub(lastCheckpoint) >= opts.Checkpoint {
+			if err := WriteShard(shardName, d); err != nil {
+				return d, report, err
+			}
+			lastCheckpoint = now
+		}
+	}
+	//the writer is done with the hour, a last line without a newline is complete
+	if len(partial) > 0 {
+		if err := report.add(d, bytes.TrimSuffix(partial, []byte("\r")), shardName, offset, fillOpts); err != nil {
+			return d, report, err
+		}
+	}
+	report.finish(fillOpts)
+	return d, report, WriteShard(shardName, d)
+}
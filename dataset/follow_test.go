@@ -0,0 +1,74 @@
+package cookieDb
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	name := dir + "/foo_2016111100.log"
+	shardName := name + ".Intersection.gob"
+	if err := os.WriteFile(name, []byte("firstCookie\t1478840400:3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hour, err := ParseTime(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every call to now is a second later, until the hour is declared over
+	var ticks, over int64
+	now := func() time.Time {
+		if atomic.LoadInt64(&over) == 1 {
+			return hour.Add(2 * time.Hour)
+		}
+		return hour.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * time.Second)
+	}
+	type result struct {
+		d      Shard
+		report *Report
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		d := make(Intersection)
+		s, report, err := Follow(name, &d, shardName, &FollowOptions{Poll: time.Millisecond, Checkpoint: 2 * time.Second, Now: now})
+		done <- result{s, report, err}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s, err := ReadShard(shardName); err == nil && s.Get("firstCookie") != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint was written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("noTab\nlastCookie\t1478840401:3")
+	f.Close()
+	atomic.StoreInt64(&over, 1)
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.report.Lines != 3 || res.report.Rejected != 1 {
+		t.Error("wrong report", res.report)
+	}
+	s, err := ReadShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 2 || s.Get("lastCookie") == nil {
+		t.Error("shard is missing the appended lines", s)
+	}
+}
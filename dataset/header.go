@@ -0,0 +1,153 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+//FormatVersion is the version of the shard files written by WriteShard, version 2 added the checksum footer
+const FormatVersion = 2
+
+//shardMagic starts every shard file that has a Header
+var shardMagic = []byte("cookieDb")
+
+//ErrNoHeader is returned for shard files written before shards had a header, MigrateShard upgrades them
+var ErrNoHeader = errors.New("shard has no header, it has to be migrated")
+
+//ErrUnsupportedVersion is returned for shard files written in a format version this build can not read,
+//e.g. by a newer one
+var ErrUnsupportedVersion = errors.New("shard format version is not supported")
+
+//Header describes the shard in a file, it is written before the shard so it can be read on its own
+type Header struct {
+	Version  int
+	Type     string
+	Timezone string
+	//Start and End are the earliest and latest event in the shard, they are zero for shards without times
+	Start time.Time
+	End   time.Time
+	Count int
+	//Replaces names the shards that were compacted into this one
+	Replaces []string
+}
+
+func newHeader(d Shard, timezone string) *Header {
+	h := &Header{Version: FormatVersion, Type: d.Type(), Timezone: timezone, Count: d.Size()}
+	for _, c := range d.GetElems(d.Size()) {
+		for _, t := range c.Time() {
+			if h.Start.IsZero() || t.Before(h.Start) {
+				h.Start = t
+			}
+			if t.After(h.End) {
+				h.End = t
+			}
+		}
+	}
+	return h
+}
+
+//rewrite returns the header for d when it replaces the shard h describes, the timezone and the replaced shards are kept
+func (h *Header) rewrite(d Shard) *Header {
+	n := newHeader(d, h.Timezone)
+	n.Replaces = h.Replaces
+	return n
+}
+
+//check tells if d is the shard h describes
+func (h *Header) check(d Shard) error {
+	if d == nil {
+		return errors.New("file holds no shard")
+	}
+	if d.Type() != h.Type {
+		return fmt.Errorf("header says %s but the file holds a %s", h.Type, d.Type())
+	}
+	if d.Size() != h.Count {
+		return fmt.Errorf("header says %d cookies but the file holds %d", h.Count, d.Size())
+	}
+	return nil
+}
+
+func writeHeader(w io.Writer, h *Header) (*gob.Encoder, error) {
+	if _, err := w.Write(shardMagic); err != nil {
+		return nil, err
+	}
+	enc := gob.NewEncoder(w)
+	return enc, enc.Encode(h)
+}
+
+//hasHeader tells if the file read by r starts with a header, without reading past it
+func hasHeader(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(shardMagic))
+	return bytes.Equal(magic, shardMagic)
+}
+
+//readHeader reads the header from r and returns the decoder to read the shard after it with
+func readHeader(r *bufio.Reader) (*Header, *gob.Decoder, error) {
+	if !hasHeader(r) {
+		return nil, nil, ErrNoHeader
+	}
+	r.Discard(len(shardMagic))
+	dec := gob.NewDecoder(r)
+	h := new(Header)
+	if err := dec.Decode(h); err != nil {
+		return nil, nil, fmt.Errorf("reading header: %v", err)
+	}
+	if err := h.checkVersion(); err != nil {
+		return nil, nil, err
+	}
+	return h, dec, nil
+}
+
+//checkVersion returns an error wrapping ErrUnsupportedVersion when this build can not read the format version of h
+func (h *Header) checkVersion() error {
+	if h.Version < 1 || h.Version > FormatVersion {
+		return fmt.Errorf("%w: version %d, this build reads up to %d", ErrUnsupportedVersion, h.Version, FormatVersion)
+	}
+	return nil
+}
+
+//ReadHeader reads only the header of the shard file shardName
+func ReadHeader(shardName string) (*Header, error) {
+	f, err := os.Open(shardName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if isIndexed(r) {
+		return readIndexedHeader(r)
+	}
+	h, _, err := readHeader(r)
+	return h, err
+}
+
+//MigrateShard rewrites the headerless shard file shardName with a header, in place. The timezone of the header
+//comes from the Meta of the shard. It returns false for a file that already has a header or is indexed.
+//The string categories of the shard are given their ids in Categories, which has to be the Dict of its store.
+func MigrateShard(shardName string) (bool, error) {
+	f, err := os.Open(shardName)
+	if err != nil {
+		return false, err
+	}
+	r := bufio.NewReader(f)
+	if hasHeader(r) || isIndexed(r) {
+		f.Close()
+		return false, nil
+	}
+	d, err := readLegacyShard(r)
+	f.Close()
+	if err != nil {
+		return false, fmt.Errorf("%s: decoding headerless shard: %v", shardName, err)
+	}
+	meta, err := ReadMeta(shardName)
+	if err != nil {
+		return false, err
+	}
+	return true, replaceShard(shardName, d, func(tmp string) error { return writeShard(tmp, d, newHeader(d, meta.Timezone)) })
+}
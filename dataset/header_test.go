@@ -0,0 +1,110 @@
+package cookieDb
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	dir := t.TempDir()
+	d := make(CountTimeSet)
+	lines := "cookieA\t1478840400:3;1478840460:4\ncookieB\t1478840401:5"
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(lines)), &d, "test_2016111100.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	shardName := dir + "/test_2016111100.log.CountTimeSet.gob"
+	if err := WriteShard(shardName, &d); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadHeader(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != FormatVersion || h.Type != "CountTimeSet" || h.Count != 2 || h.Timezone != FileTimes.Loc().String() ||
+		!h.Start.Equal(time.Unix(1478840400, 0)) || !h.End.Equal(time.Unix(1478840460, 0)) {
+		t.Error("wrong header", h)
+	}
+
+	//a header that does not match the shard is refused
+	f, _ := os.Create(shardName)
+	enc, _ := writeHeader(f, &Header{Version: FormatVersion, Type: "StatSet", Count: 2})
+	writeToEncoder(enc, &d)
+	f.Close()
+	if _, err := ReadShard(shardName); err == nil {
+		t.Error("expected an error for a shard of the wrong type")
+	}
+	f, _ = os.Create(shardName)
+	writeHeader(f, &Header{Version: FormatVersion + 1, Type: "CountTimeSet", Count: 2})
+	f.Close()
+	if _, err := ReadHeader(shardName); err == nil {
+		t.Error("expected an error for a newer format")
+	}
+}
+
+func TestMigrateBaselineShard(t *testing.T) {
+	dir := t.TempDir()
+	saved := Categories
+	defer func() { Categories = saved }()
+	Categories = NewDict(DictPath(dir))
+	//fixtures.StatSet.gob is a StatSet of the fixtures written before shards had a header, with string categories
+	data, err := os.ReadFile("fixtures.StatSet.gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardName := dir + "/test_2016111100.log.StatSet.gob"
+	os.WriteFile(shardName, data, 0644)
+	if migrated, err := MigrateShard(shardName); err != nil || !migrated {
+		t.Fatal("baseline shard was not migrated", err)
+	}
+	Categories, err = LoadDict(DictPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Get("4xI6BF6Q99OeeENR")
+	if c == nil || len(c.Cats()) == 0 || c.Cats()[0] != "2090" {
+		t.Error("migrated shard lost its categories", c)
+	}
+}
+
+func TestMigrateShard(t *testing.T) {
+	dir := t.TempDir()
+	shardName := dir + "/test_2016111100.log.Intersection.gob"
+	d := make(Intersection)
+	d["cookieA"] = struct{}{}
+	f, err := os.Create(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeToEncoder(gob.NewEncoder(f), &d)
+	f.Close()
+	if err := WriteMeta(shardName, &Meta{Type: "Intersection", Timezone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadShard(shardName); !errors.Is(err, ErrNoHeader) {
+		t.Error("expected ErrNoHeader, got", err)
+	}
+	migrated, err := MigrateShard(shardName)
+	if err != nil || !migrated {
+		t.Fatal("shard was not migrated", err)
+	}
+	s, err := ReadShard(shardName)
+	if err != nil || s.Get("cookieA") == nil {
+		t.Error("migrated shard does not read back", err)
+	}
+	if h, _ := ReadHeader(shardName); h.Timezone != "UTC" {
+		t.Error("migrated header does not have the timezone of the meta", h)
+	}
+	if migrated, err := MigrateShard(shardName); migrated || err != nil {
+		t.Error("shard with a header was migrated again", err)
+	}
+}
@@ -0,0 +1,662 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+//An indexed shard file holds the cookies of a shard sorted by id, so one cookie can be read without decoding the rest:
+//
+//	magic "cookieIx"
+//	uvarint length, gob encoded Header
+//	records: uvarint id length, id, uvarint value length, value
+//	index: uvarint n, n times uvarint id length, id, uvarint offset of the record
+//	offset of the index as a little endian uint64
+//
+//The index holds every indexInterval'th record, a lookup reads the records between two index entries.
+
+var indexedMagic = []byte("cookieIx")
+
+//indexInterval is the number of records between two entries of the sparse index
+const indexInterval = 64
+
+var errReadOnly = errors.New("indexed shards are read only")
+
+type indexEntry struct {
+	id     string
+	offset int64
+}
+
+//IndexedShard is a read only Shard backed by an indexed shard file, Get reads only the records it needs.
+//It is safe for concurrent readers.
+type IndexedShard struct {
+	f        *os.File
+	header   *Header
+	index    []indexEntry
+	indexOff int64
+}
+
+//WriteIndexedShard writes d to fileName in the indexed format, Categories is saved first and the file is renamed
+//into place like WriteShard does
+func WriteIndexedShard(fileName string, d Shard) error {
+	h := newHeader(d, FileTimes.Loc().String())
+	return replaceShard(fileName, d, func(tmp string) error { return writeIndexedShard(tmp, d, h) })
+}
+
+func writeIndexedShard(fileName string, d Shard, h *Header) error {
+	if err := Categories.Save(); err != nil {
+		return err
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum := crc32.New(crcTable)
+	w := &countWriter{w: bufio.NewWriter(io.MultiWriter(f, sum))}
+	if err := writeIndexed(w, d, h); err != nil {
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := writeChecksum(f, sum.Sum32()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func writeIndexed(w *countWriter, d Shard, h *Header) error {
+	var header bytes.Buffer
+	if err := gob.NewEncoder(&header).Encode(h); err != nil {
+		return err
+	}
+	var buf []byte
+	buf = append(buf, indexedMagic...)
+	buf = binary.AppendUvarint(buf, uint64(header.Len()))
+	buf = append(buf, header.Bytes()...)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	ids, err := shardIDs(d)
+	if err != nil {
+		return err
+	}
+	var index []indexEntry
+	for i, id := range ids {
+		if i%indexInterval == 0 {
+			index = append(index, indexEntry{id, w.n})
+		}
+		rw := new(recWriter)
+		if err := encodeValue(rw, d, id); err != nil {
+			return err
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(id)))
+		buf = append(buf, id...)
+		buf = binary.AppendUvarint(buf, uint64(len(rw.buf)))
+		buf = append(buf, rw.buf...)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	indexOff := w.n
+	buf = binary.AppendUvarint(buf[:0], uint64(len(index)))
+	for _, e := range index {
+		buf = binary.AppendUvarint(buf, uint64(len(e.id)))
+		buf = append(buf, e.id...)
+		buf = binary.AppendUvarint(buf, uint64(e.offset))
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(indexOff))
+	_, err = w.Write(buf)
+	return err
+}
+
+//shardIDs returns the sorted cookie ids of d
+func shardIDs(d Shard) ([]string, error) {
+	var ids []string
+	switch set := d.(type) {
+	case *Intersection:
+		for id := range *set {
+			ids = append(ids, id)
+		}
+	case *CountTimeSet:
+		for id := range *set {
+			ids = append(ids, id)
+		}
+	case *CountTimeCatsSet:
+		for id := range *set {
+			ids = append(ids, id)
+		}
+	case *StatSet:
+		for id := range *set {
+			ids = append(ids, id)
+		}
+	default:
+		return nil, fmt.Errorf("%s can not be indexed", d.Type())
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+//isIndexed tells if the file read by r is an indexed shard, without reading from it
+func isIndexed(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(indexedMagic))
+	return bytes.Equal(magic, indexedMagic)
+}
+
+//readIndexedHeader reads the header of an indexed shard from r
+func readIndexedHeader(r *bufio.Reader) (*Header, error) {
+	if _, err := r.Discard(len(indexedMagic)); err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	h := new(Header)
+	if err := gob.NewDecoder(io.LimitReader(r, int64(n))).Decode(h); err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	if err := h.checkVersion(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+//OpenIndexedShard opens the indexed shard file fileName, it reads the header and the sparse index only
+func OpenIndexedShard(fileName string) (*IndexedShard, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openIndexed(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return s, nil
+}
+
+func openIndexed(f *os.File) (*IndexedShard, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	if !isIndexed(r) {
+		return nil, errors.New("not an indexed shard")
+	}
+	s := &IndexedShard{f: f}
+	if s.header, err = readIndexedHeader(r); err != nil {
+		return nil, err
+	}
+	end := info.Size() - 8 - s.header.footerSize()
+	if end < 0 {
+		return nil, errors.New("file is truncated")
+	}
+	var footer [8]byte
+	if _, err := f.ReadAt(footer[:], end); err != nil {
+		return nil, err
+	}
+	s.indexOff = int64(binary.LittleEndian.Uint64(footer[:]))
+	if s.indexOff < 0 || s.indexOff > end {
+		return nil, errors.New("index offset is out of the file")
+	}
+	block := make([]byte, end-s.indexOff)
+	if _, err := f.ReadAt(block, s.indexOff); err != nil {
+		return nil, err
+	}
+	if s.index, err = parseIndex(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//parseIndex decodes the index block of an indexed shard
+func parseIndex(block []byte) ([]indexEntry, error) {
+	var index []indexEntry
+	rr := &recReader{buf: block}
+	n := rr.uvarint()
+	for i := uint64(0); i < n && rr.err == nil; i++ {
+		index = append(index, indexEntry{rr.str(), int64(rr.uvarint())})
+	}
+	if rr.err != nil {
+		return nil, fmt.Errorf("reading index: %v", rr.err)
+	}
+	return index, nil
+}
+
+//Header returns the header of the shard
+func (s *IndexedShard) Header() *Header {
+	return s.header
+}
+
+//Close closes the shard file
+func (s *IndexedShard) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+func (s *IndexedShard) Add(line []byte, fileName string) error {
+	return errReadOnly
+}
+
+func (s *IndexedShard) Size() int {
+	return s.header.Count
+}
+
+//Init closes the shard file and leaves an empty shard
+func (s *IndexedShard) Init() {
+	s.Close()
+	s.f = nil
+	s.index = nil
+	s.header = &Header{Version: FormatVersion, Type: s.header.Type}
+}
+
+func (s *IndexedShard) Type() string {
+	return s.header.Type
+}
+
+//GetElems decodes nr cookies of the shard, in the order of their ids from a random one on,
+//starting over at the first after the last. Like the map order of the other shards, every call gives another sample.
+func (s *IndexedShard) GetElems(nr int) []Cookie {
+	ret := make([]Cookie, 0, nr)
+	if len(s.index) == 0 {
+		return ret
+	}
+	start := sampleStart(nr, s.Size())
+	ret = s.elems(ret, start, start+nr)
+	if start > 0 {
+		ret = s.elems(ret, 0, nr-len(ret))
+	}
+	return ret
+}
+
+//elems appends the cookies of the records from up to to to ret
+func (s *IndexedShard) elems(ret []Cookie, from, to int) []Cookie {
+	if from >= to {
+		return ret
+	}
+	e := s.index[from/indexInterval]
+	r := bufio.NewReader(io.NewSectionReader(s.f, e.offset, s.indexOff-e.offset))
+	for i := from - from%indexInterval; i < to; i++ {
+		id, value, err := readIndexedRecord(r)
+		if err != nil {
+			break
+		}
+		if i < from {
+			continue
+		}
+		c, err := decodeValue(s.header.Type, id, value)
+		if err != nil {
+			break
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+//sampleStart returns the record GetElems of an indexed shard of size cookies starts at, a random one,
+//or the first when all of them are read
+func sampleStart(nr, size int) int {
+	if nr >= size {
+		return 0
+	}
+	return rand.Intn(size)
+}
+
+//Load decodes every cookie of the shard into the Shard type it was written from
+func (s *IndexedShard) Load() (Shard, error) {
+	return loadCookies(s.header.Type, s.GetElems(s.Size()), s.Size())
+}
+
+//loadCookies puts the decoded cookies of an indexed shard of size cookies into a Shard of type shardType
+func loadCookies(shardType string, cookies []Cookie, size int) (Shard, error) {
+	d, err := newShard(shardType)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cookies {
+		switch set := d.(type) {
+		case *Intersection:
+			(*set)[c.ID()] = struct{}{}
+		case *CountTimeSet:
+			ct := c.(cookieCountTime).Ct
+			(*set)[c.ID()] = &ct
+		case *CountTimeCatsSet:
+			(*set)[c.ID()] = c.(*CountTimeCats)
+		case *StatSet:
+			(*set)[c.ID()] = c.User()
+		}
+	}
+	if d.Size() != size {
+		return nil, fmt.Errorf("decoded %d of the %d cookies", d.Size(), size)
+	}
+	return d, nil
+}
+
+//newShard returns an empty Shard of type shardType
+func newShard(shardType string) (Shard, error) {
+	var d Shard
+	switch shardType {
+	case "Intersection":
+		d = &Intersection{}
+	case "CountTimeSet":
+		d = &CountTimeSet{}
+	case "CountTimeCatsSet":
+		d = &CountTimeCatsSet{}
+	case "StatSet":
+		d = &StatSet{}
+	default:
+		return nil, fmt.Errorf("unknown shard type %s", shardType)
+	}
+	d.Init()
+	return d, nil
+}
+
+func readIndexedRecord(r *bufio.Reader) (string, []byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, err
+	}
+	id := make([]byte, n)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", nil, err
+	}
+	if n, err = binary.ReadUvarint(r); err != nil {
+		return "", nil, err
+	}
+	value := make([]byte, n)
+	_, err = io.ReadFull(r, value)
+	return string(id), value, err
+}
+
+//Get reads the block of records that can hold cookieID and decodes only its record
+func (s *IndexedShard) Get(cookieID string) Cookie {
+	start, end, ok := blockOf(s.index, s.indexOff, cookieID)
+	if !ok {
+		return nil
+	}
+	block := make([]byte, end-start)
+	if _, err := s.f.ReadAt(block, start); err != nil {
+		return nil
+	}
+	return findInBlock(block, s.header.Type, cookieID)
+}
+
+//blockOf binary searches the sparse index for the offsets of the block of records that can hold cookieID,
+//ok is false when cookieID sorts before every record
+func blockOf(index []indexEntry, indexOff int64, cookieID string) (start, end int64, ok bool) {
+	i := sort.Search(len(index), func(i int) bool { return index[i].id > cookieID }) - 1
+	if i < 0 {
+		return 0, 0, false
+	}
+	end = indexOff
+	if i+1 < len(index) {
+		end = index[i+1].offset
+	}
+	return index[i].offset, end, true
+}
+
+//findInBlock decodes the record of cookieID in a block of records, or returns nil when it is not there
+func findInBlock(block []byte, shardType, cookieID string) Cookie {
+	rr := &recReader{buf: block}
+	for len(rr.buf) > 0 && rr.err == nil {
+		id := rr.bytes()
+		value := rr.bytes()
+		if string(id) == cookieID {
+			c, err := decodeValue(shardType, cookieID, value)
+			if err != nil {
+				return nil
+			}
+			return c
+		}
+		if string(id) > cookieID {
+			break
+		}
+	}
+	return nil
+}
+
+type recWriter struct {
+	buf []byte
+}
+
+func (w *recWriter) uvarint(x uint64) {
+	w.buf = binary.AppendUvarint(w.buf, x)
+}
+
+func (w *recWriter) varint(x int64) {
+	w.buf = binary.AppendVarint(w.buf, x)
+}
+
+func (w *recWriter) str(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *recWriter) bool(b bool) {
+	if b {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *recWriter) time(t time.Time) error {
+	b, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.buf = append(w.buf, byte(len(b)))
+	w.buf = append(w.buf, b...)
+	return nil
+}
+
+func (w *recWriter) times(ts []time.Time) error {
+	w.uvarint(uint64(len(ts)))
+	for _, t := range ts {
+		if err := w.time(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *recWriter) cats(cats []CatID) {
+	w.uvarint(uint64(len(cats)))
+	for _, c := range cats {
+		w.uvarint(uint64(c))
+	}
+}
+
+type recReader struct {
+	buf []byte
+	err error
+}
+
+var errShortRecord = errors.New("record is cut short")
+
+func (r *recReader) uvarint() uint64 {
+	x, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+func (r *recReader) varint() int64 {
+	x, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+func (r *recReader) fail() {
+	if r.err == nil {
+		r.err = errShortRecord
+	}
+	r.buf = nil
+}
+
+func (r *recReader) bytes() []byte {
+	n := r.uvarint()
+	if uint64(len(r.buf)) < n {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *recReader) str() string {
+	return string(r.bytes())
+}
+
+func (r *recReader) bool() bool {
+	if len(r.buf) == 0 {
+		r.fail()
+		return false
+	}
+	b := r.buf[0] == 1
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *recReader) time() time.Time {
+	if len(r.buf) == 0 {
+		r.fail()
+		return time.Time{}
+	}
+	n := int(r.buf[0])
+	if len(r.buf) < n+1 {
+		r.fail()
+		return time.Time{}
+	}
+	var t time.Time
+	if err := t.UnmarshalBinary(r.buf[1 : n+1]); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.buf = r.buf[n+1:]
+	return t
+}
+
+func (r *recReader) times() []time.Time {
+	n := r.uvarint()
+	var ts []time.Time
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		ts = append(ts, r.time())
+	}
+	return ts
+}
+
+func (r *recReader) cats() []CatID {
+	n := r.uvarint()
+	var cats []CatID
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		cats = append(cats, CatID(r.uvarint()))
+	}
+	return cats
+}
+
+//encodeValue writes what d holds for id
+func encodeValue(w *recWriter, d Shard, id string) error {
+	switch set := d.(type) {
+	case *Intersection:
+		return nil
+	case *CountTimeSet:
+		c := (*set)[id]
+		w.varint(int64(c.Count))
+		return w.times(c.TStamp)
+	case *CountTimeCatsSet:
+		c := (*set)[id]
+		w.varint(int64(c.Counter))
+		w.cats(c.Categories)
+		return w.times(c.TStamp)
+	case *StatSet:
+		u := (*set)[id]
+		w.bool(u.Current)
+		w.uvarint(uint64(len(u.Sess)))
+		for _, s := range u.Sess {
+			w.str(s.File)
+			w.bool(s.Hist)
+			w.bool(s.Current)
+			w.uvarint(uint64(len(s.Events)))
+			for _, e := range s.Events {
+				if err := w.time(e.T); err != nil {
+					return err
+				}
+				w.cats(e.Cats)
+				w.bool(e.His)
+				w.bool(e.Current)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s can not be indexed", d.Type())
+}
+
+//decodeValue decodes a value written by encodeValue for a shard of type shardType
+func decodeValue(shardType, id string, value []byte) (Cookie, error) {
+	r := &recReader{buf: value}
+	var c Cookie
+	switch shardType {
+	case "Intersection":
+		c = cookieInter(id)
+	case "CountTimeSet":
+		ct := CountTime{Count: int(r.varint())}
+		ct.TStamp = r.times()
+		c = cookieCountTime{id, ct}
+	case "CountTimeCatsSet":
+		ct := &CountTimeCats{CookieID: id, Counter: int(r.varint())}
+		ct.Categories = r.cats()
+		ct.TStamp = r.times()
+		c = ct
+	case "StatSet":
+		u := &User{CookieID: id, Current: r.bool()}
+		n := r.uvarint()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			s := Session{File: r.str(), Hist: r.bool(), Current: r.bool()}
+			ne := r.uvarint()
+			for j := uint64(0); j < ne && r.err == nil; j++ {
+				e := Event{T: r.time(), Cats: r.cats()}
+				e.His, e.Current = r.bool(), r.bool()
+				s.Events = append(s.Events, e)
+			}
+			u.Sess = append(u.Sess, s)
+		}
+		c = u
+	default:
+		return nil, fmt.Errorf("unknown shard type %s", shardType)
+	}
+	return c, r.err
+}
@@ -0,0 +1,138 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//manyCookies returns n lines with a cookie each, every third cookie shows up twice
+func manyCookies(n int) string {
+	var lines []string
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf("cookie%04d\t%d:%d,%d;%d:%d", i, 1478840400+i, i%7, i%11, 1478840500+i, i%13))
+		if i%3 == 0 {
+			lines = append(lines, fmt.Sprintf("cookie%04d\t%d:1", i, 1478841000+i))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+//checkSamples checks that GetElems of s reads consecutive cookies from another one every time
+func checkSamples(t *testing.T, s Shard) {
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		elems := s.GetElems(5)
+		if len(elems) != 5 {
+			t.Fatal(s.Type(), "wrong number of elems", len(elems))
+		}
+		for j := 1; j < len(elems); j++ {
+			if elems[j].ID() <= elems[j-1].ID() && elems[j].ID() != "cookie0000" {
+				t.Error(s.Type(), "elems are not consecutive", elems[j-1].ID(), elems[j].ID())
+			}
+		}
+		seen[elems[0].ID()] = true
+	}
+	if len(seen) < 2 {
+		t.Error(s.Type(), "every sample is the same", seen)
+	}
+}
+
+func sameCookie(a, b Cookie) bool {
+	if a.ID() != b.ID() || a.Count() != b.Count() || !reflect.DeepEqual(a.Cats(), b.Cats()) || len(a.Time()) != len(b.Time()) {
+		return false
+	}
+	for i, t := range a.Time() {
+		if !t.Equal(b.Time()[i]) {
+			return false
+		}
+	}
+	if a.User() != nil && a.User().String() != b.User().String() {
+		return false
+	}
+	return true
+}
+
+func TestIndexedShard(t *testing.T) {
+	dir := t.TempDir()
+	lines := manyCookies(300)
+	for _, newShard := range []func() Shard{
+		func() Shard { d := make(Intersection); return &d },
+		func() Shard { d := make(CountTimeSet); return &d },
+		func() Shard { d := make(CountTimeCatsSet); return &d },
+		func() Shard { d := make(StatSet); return &d },
+	} {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(lines)), newShard(), "test_2016111100.log", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shardName := dir + "/test_2016111100.log." + d.Type() + ".gob"
+		if err := WriteIndexedShard(shardName, d); err != nil {
+			t.Fatal(err)
+		}
+		s, err := ReadShard(shardName)
+		if err != nil {
+			t.Fatal(d.Type(), err)
+		}
+		if _, ok := s.(*IndexedShard); !ok || s.Type() != d.Type() || s.Size() != 300 {
+			t.Fatalf("%s: wrong shard %T %d", d.Type(), s, s.Size())
+		}
+		for _, c := range d.GetElems(d.Size()) {
+			got := s.Get(c.ID())
+			if got == nil || !sameCookie(got, c) {
+				t.Errorf("%s: %s does not read back: %v, expected %v", d.Type(), c.ID(), got, c)
+			}
+		}
+		for _, id := range []string{"", "cookie", "cookie0000x", "cookie0299x", "zzz"} {
+			if s.Get(id) != nil {
+				t.Errorf("%s: found %q", d.Type(), id)
+			}
+		}
+		if elems := s.GetElems(s.Size()); len(elems) != 300 || elems[0].ID() != "cookie0000" || elems[299].ID() != "cookie0299" {
+			t.Error(d.Type(), "wrong elems", len(elems))
+		}
+		checkSamples(t, s)
+		if h, err := ReadHeader(shardName); err != nil || h.Count != 300 || h.Type != d.Type() {
+			t.Error(d.Type(), "wrong header", h, err)
+		}
+		if err := s.Add([]byte(LINE), "test_2016111100.log"); err == nil {
+			t.Error(d.Type(), "indexed shard accepted a line")
+		}
+		s.(*IndexedShard).Close()
+	}
+
+	shardName := dir + "/test_2016111100.log.StatSet.gob"
+	if _, err := AppendShard(shardName, "late_2016111100.log", bufio.NewScanner(strings.NewReader("late\t1478840400:3")), nil); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenIndexedShard(shardName)
+	if err != nil {
+		t.Fatal("appended shard is not indexed anymore", err)
+	}
+	if s.Size() != 301 || s.Get("late") == nil || s.Get("cookie0299") == nil {
+		t.Error("wrong shard after append", s.Size())
+	}
+	s.Close()
+
+	empty := make(StatSet)
+	shardName = dir + "/empty.gob"
+	if err := WriteIndexedShard(shardName, &empty); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenIndexedShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 0 || s.Get("cookie0000") != nil || len(s.GetElems(1)) != 0 {
+		t.Error("empty shard is not empty")
+	}
+	s.Close()
+
+	os.WriteFile(shardName, append([]byte("cookieIx"), 0), 0644)
+	if _, err := OpenIndexedShard(shardName); err == nil {
+		t.Error("expected an error for a broken file")
+	}
+}
@@ -0,0 +1,95 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type compression int
+
+const (
+	plain compression = iota
+	gzipped
+	bzipped
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	//a bzip2 stream goes on with the magic of its first block, or of its end when it is empty
+	bzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+//bzip2HeadSize is the length of the bzip2 header and the block magic after it
+const bzip2HeadSize = 10
+
+//isBzip2 tells if head starts with a whole bzip2 header, "BZh" alone is too likely to start a line of text
+func isBzip2(head []byte) bool {
+	if len(head) < bzip2HeadSize || !bytes.HasPrefix(head, bzip2Magic) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	magic := head[4:bzip2HeadSize]
+	return bytes.Equal(magic, bzip2Block) || bytes.Equal(magic, bzip2End)
+}
+
+//detectCompression looks at the first bytes of the input and falls back to the file extension
+func detectCompression(name string, head []byte) compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzipped
+	case isBzip2(head):
+		return bzipped
+	}
+	switch filepath.Ext(name) {
+	case ".gz":
+		return gzipped
+	case ".bz2":
+		return bzipped
+	}
+	return plain
+}
+
+type input struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (in *input) Close() error {
+	var err error
+	for _, c := range in.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//OpenInput opens the log file name for reading, gzip and bzip2 files are decompressed as they are read
+func OpenInput(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	head, _ := r.Peek(bzip2HeadSize)
+	in := &input{Reader: r, closers: []io.Closer{f}}
+	switch detectCompression(name, head) {
+	case gzipped:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		in.Reader = zr
+		in.closers = append([]io.Closer{zr}, in.closers...)
+	case bzipped:
+		in.Reader = bzip2.NewReader(r)
+	}
+	return in, nil
+}
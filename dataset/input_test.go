@@ -0,0 +1,60 @@
+package cookieDb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"testing"
+)
+
+//BZIPPED is the first line of the fixtures compressed with bzip2
+var BZIPPED = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x79\x7c\x58\xbf\x00\x00\x12\x5f\x80\x00\x30\x00\x04\x7e\xf0\x14\x00\x2a\x00\x00\x02\x8a\x90\x20\x00\x31\x41\xa3\x46\x83\x20\x34\x22\x9e\x43\x51\xb2\x23\x27\x91\xa6\x4f\xb4\x50\x53\xf0\xc0\x46\x44\x9f\xfa\x53\xa5\x84\xa4\x17\x6f\x82\x6c\xd1\x0e\xfa\x8b\xd1\x23\x82\xee\x48\xa7\x0a\x12\x0f\x2f\x8b\x17\xe0"
+
+func TestOpenInput(t *testing.T) {
+	dir := t.TempDir()
+	line := "m2uszQDo999wwSBU\t1480551255:3,17,218,25222"
+
+	gzName := dir + "/foo_2016111100.log.gz"
+	f, err := os.Create(gzName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(line + "\n"))
+	zw.Close()
+	f.Close()
+
+	//a bzip2 file without its extension is still recognised by its magic bytes
+	bzName := dir + "/foo_2016111101.log"
+	if err := os.WriteFile(bzName, []byte(BZIPPED), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//a plain log whose first cookie id starts like a bzip2 header is read as it is
+	plainName := dir + "/foo_2016111102.log"
+	if err := os.WriteFile(plainName, []byte("BZh9xQDo999wwSBU\t1480551255:3\n"+line+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{gzName, bzName, plainName, "fixtures"} {
+		in, err := OpenInput(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		d := make(StatSet)
+		if _, _, err := FillDb(bufio.NewScanner(in), &d, "test_2016111100.log", &FillOptions{Policy: AbortOnBadLine}); err != nil {
+			t.Error(name, err)
+		}
+		in.Close()
+		if d.Get("m2uszQDo999wwSBU") == nil {
+			t.Error(name, "first cookie is missing")
+		}
+	}
+
+	if got, err := ParseTime(gzName); err != nil || got.Hour() != 0 || got.Day() != 11 {
+		t.Error("wrong time for compressed file name", got, err)
+	}
+	if _, err := ParseTime("clicks.log"); err == nil {
+		t.Error("expected an error for a name without a time")
+	}
+}
@@ -0,0 +1,97 @@
+package cookieDb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+//legacyStatSet and legacyCountTimeCatsSet are the shards as they were written before categories were encoded
+//by a Dict, with the categories as strings. The other shards are encoded the same as they were.
+type legacyStatSet map[string]*legacyUser
+
+type legacyUser struct {
+	CookieID string
+	Sess     []legacySession
+	Current  bool
+}
+
+type legacySession struct {
+	Events  []legacyEvent
+	File    string
+	Hist    bool
+	Current bool
+}
+
+type legacyEvent struct {
+	T       time.Time
+	Cats    []string
+	His     bool
+	Current bool
+}
+
+type legacyCountTimeCatsSet map[string]*legacyCountTimeCats
+
+type legacyCountTimeCats struct {
+	Counter    int
+	TStamp     []time.Time
+	Categories []string
+	CookieID   string
+}
+
+//legacyNames maps the names the shards with string categories were registered with in gob to the names their
+//legacy types are registered with. The names are of the same length, so they are swapped in place in a file.
+var legacyNames = map[string]string{
+	"*cookieDb.StatSet":          "~cookieDb.StatSet",
+	"*cookieDb.CountTimeCatsSet": "~cookieDb.CountTimeCatsSet",
+}
+
+func init() {
+	gob.RegisterName(legacyNames["*cookieDb.StatSet"], &legacyStatSet{})
+	gob.RegisterName(legacyNames["*cookieDb.CountTimeCatsSet"], &legacyCountTimeCatsSet{})
+}
+
+//readLegacyShard decodes a shard written before shards had a header. The categories of StatSet and
+//CountTimeCatsSet shards were strings then, they are given their ids in Categories.
+func readLegacyShard(r io.Reader) (Shard, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	//the shard is encoded as an interface, whose concrete type is named by its length and name first
+	for name, legacy := range legacyNames {
+		data = bytes.Replace(data, append([]byte{byte(len(name))}, name...), append([]byte{byte(len(legacy))}, legacy...), 1)
+	}
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		return nil, err
+	}
+	switch set := v.(type) {
+	case *legacyStatSet:
+		d := make(StatSet, len(*set))
+		for id, u := range *set {
+			user := &User{CookieID: u.CookieID, Current: u.Current}
+			for _, s := range u.Sess {
+				sess := Session{File: s.File, Hist: s.Hist, Current: s.Current}
+				for _, e := range s.Events {
+					sess.Events = append(sess.Events, Event{T: e.T, Cats: Categories.IDs(e.Cats), His: e.His, Current: e.Current})
+				}
+				user.Sess = append(user.Sess, sess)
+			}
+			d[id] = user
+		}
+		return &d, nil
+	case *legacyCountTimeCatsSet:
+		d := make(CountTimeCatsSet, len(*set))
+		for id, c := range *set {
+			d[id] = &CountTimeCats{Counter: c.Counter, TStamp: c.TStamp, Categories: Categories.IDs(c.Categories), CookieID: c.CookieID}
+		}
+		return &d, nil
+	case Shard:
+		return set, nil
+	}
+	return nil, fmt.Errorf("file holds a %T, not a shard", v)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package cookieDb
+
+import "os"
+
+//lockFile does not lock f, on this platform a store has to be written by one process at a time
+func lockFile(f *os.File) error {
+	return nil
+}
+
+//flockFile does not lock f either
+func flockFile(f *os.File, exclusive, wait bool) error {
+	return nil
+}
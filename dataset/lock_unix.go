@@ -0,0 +1,30 @@
+//go:build unix
+
+package cookieDb
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile waits for an exclusive lock on f, the lock is held until f is closed
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+//flockFile takes a shared or an exclusive lock on f, held until f is closed or locked again. Unless wait is set
+//it returns errLocked at once when the lock is held by another open file.
+func flockFile(f *os.File, exclusive, wait bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(f.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
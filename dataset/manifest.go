@@ -0,0 +1,285 @@
+package cookieDb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//IngestOptions are the parser settings a shard is built with, a shard built with other settings is stale
+type IngestOptions struct {
+	Policy       string `json:"policy"`
+	Stamps       string `json:"stamps"`
+	TimePattern  string `json:"timePattern"`
+	TimeLayout   string `json:"timeLayout"`
+	TimeFallback string `json:"timeFallback"`
+	Timezone     string `json:"timezone"`
+}
+
+//CurrentOptions returns the IngestOptions of policy, Stamps and FileTimes
+func CurrentOptions(policy BadLinePolicy) IngestOptions {
+	return IngestOptions{
+		Policy:       policy.String(),
+		Stamps:       Stamps.String(),
+		TimePattern:  FileTimes.Pattern.String(),
+		TimeLayout:   FileTimes.Layout,
+		TimeFallback: FileTimes.Fallback.String(),
+		Timezone:     FileTimes.Loc().String(),
+	}
+}
+
+//Source identifies the contents of the log file a shard is built from
+type Source struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"sha256"`
+}
+
+//statSource returns the Source of path, the file is only read to hash it when hash is set
+func statSource(path string, hash bool) (Source, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Source{}, err
+	}
+	src := Source{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	if hash {
+		src.Hash, err = hashFile(path)
+	}
+	return src, err
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//ManifestEntry records how a shard was built
+type ManifestEntry struct {
+	Type    string        `json:"type"`
+	Source  Source        `json:"source"`
+	Options IngestOptions `json:"options"`
+	BuiltAt time.Time     `json:"builtAt"`
+	//Appended are the inputs that were folded into the shard by AppendShard after it was built,
+	//a rebuild from Source has to append them again
+	Appended []Source `json:"appended,omitempty"`
+}
+
+//Manifest holds the ManifestEntry of every shard of a store, keyed by the name of the shard file in the store dir
+//so the store can be moved or restored to another dir. It is safe for concurrent use, also by several processes
+//building shards of one store.
+type Manifest struct {
+	path    string
+	mu      sync.Mutex
+	Entries map[string]*ManifestEntry `json:"shards"`
+}
+
+//ManifestPath returns the file in the store dir that holds its manifest
+func ManifestPath(dir string) string {
+	return filepath.Join(dir, "manifest.json")
+}
+
+//LoadManifest reads the manifest saved at path, a missing file gives an empty manifest
+func LoadManifest(path string) (*Manifest, error) {
+	entries, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{path: path, Entries: entries}, nil
+}
+
+//readManifest returns the entries saved at path keyed by manifestKey, a missing file has none
+func readManifest(path string) (map[string]*ManifestEntry, error) {
+	var m Manifest
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return make(map[string]*ManifestEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*ManifestEntry, len(m.Entries))
+	for shardName, e := range m.Entries {
+		//manifests used to key entries by the path of the shard
+		entries[manifestKey(shardName)] = e
+	}
+	return entries, nil
+}
+
+//manifestKey returns the key of the entry of shardName
+func manifestKey(shardName string) string {
+	return filepath.Base(shardName)
+}
+
+//Entry returns the entry of shardName, or nil
+func (m *Manifest) Entry(shardName string) *ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Entries[manifestKey(shardName)]
+}
+
+//Fresh tells if shardName exists and was built as a shardType from the current contents of source with opts.
+//A source that was touched but still hashes the same keeps its shard, the entry is updated to its new mtime.
+func (m *Manifest) Fresh(shardName, shardType, source string, opts IngestOptions) (bool, error) {
+	if _, err := os.Stat(shardName); os.IsNotExist(err) {
+		return false, nil
+	}
+	e := m.Entry(shardName)
+	if e == nil || e.Type != shardType || e.Options != opts || e.Source.Path != source {
+		return false, nil
+	}
+	src, err := statSource(source, false)
+	if err != nil {
+		return false, err
+	}
+	if src.Size == e.Source.Size && src.ModTime.Equal(e.Source.ModTime) {
+		return true, nil
+	}
+	if src.Size != e.Source.Size {
+		return false, nil
+	}
+	if src.Hash, err = hashFile(source); err != nil || src.Hash != e.Source.Hash {
+		return false, err
+	}
+	return true, m.update(func(entries map[string]*ManifestEntry) error {
+		if e := entries[manifestKey(shardName)]; e != nil {
+			e.Source = src
+		}
+		return nil
+	})
+}
+
+//Record adds the entry of shardName, just built as a shardType from source with opts, and saves the manifest
+func (m *Manifest) Record(shardName, shardType, source string, opts IngestOptions) error {
+	src, err := statSource(source, true)
+	if err != nil {
+		return err
+	}
+	return m.update(func(entries map[string]*ManifestEntry) error {
+		entries[manifestKey(shardName)] = &ManifestEntry{Type: shardType, Source: src, Options: opts, BuiltAt: time.Now()}
+		return nil
+	})
+}
+
+//ErrAlreadyAppended is returned by RecordAppend for an input that was appended to the shard before
+var ErrAlreadyAppended = errors.New("input is already appended to the shard")
+
+//appended tells if src, with the same path and contents, is one of the inputs appended to the shard of e
+func (e *ManifestEntry) appended(src Source) bool {
+	for _, a := range e.Appended {
+		if a.Path == src.Path && a.Hash == src.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+//Appended tells if the current contents of source were appended to shardName already, appending them again
+//would count their lines twice. The saved manifest is read, another process may have appended them since it was loaded.
+func (m *Manifest) Appended(shardName, source string) (bool, error) {
+	entries, err := readManifest(m.path)
+	if err != nil {
+		return false, err
+	}
+	e := entries[manifestKey(shardName)]
+	if e == nil || len(e.Appended) == 0 {
+		return false, nil
+	}
+	src, err := statSource(source, true)
+	if err != nil {
+		return false, err
+	}
+	return e.appended(src), nil
+}
+
+//RecordAppend adds source to the inputs that were appended to shardName and saves the manifest,
+//shardName has to be recorded already. ErrAlreadyAppended is returned when it is one of them already.
+func (m *Manifest) RecordAppend(shardName, source string) error {
+	src, err := statSource(source, true)
+	if err != nil {
+		return err
+	}
+	return m.update(func(entries map[string]*ManifestEntry) error {
+		e, ok := entries[manifestKey(shardName)]
+		if !ok {
+			return fmt.Errorf("%s is not in the manifest, the input appended to it can not be recorded", shardName)
+		}
+		if e.appended(src) {
+			return fmt.Errorf("%s: %w", source, ErrAlreadyAppended)
+		}
+		e.Appended = append(e.Appended, src)
+		return nil
+	})
+}
+
+//Delete drops the entry of shardName, e.g. once it was compacted away, and saves the manifest
+func (m *Manifest) Delete(shardName string) error {
+	return m.update(func(entries map[string]*ManifestEntry) error {
+		delete(entries, manifestKey(shardName))
+		return nil
+	})
+}
+
+//moveSources keys the entries of the shards in renamed by their new names and gives every source, and every input
+//appended to a shard, the path returned by move
+func (m *Manifest) moveSources(renamed map[string]string, move func(path string) string) error {
+	return m.update(func(entries map[string]*ManifestEntry) error {
+		for from, to := range renamed {
+			if e, ok := entries[manifestKey(from)]; ok {
+				delete(entries, manifestKey(from))
+				entries[manifestKey(to)] = e
+			}
+		}
+		for _, e := range entries {
+			e.Source.Path = move(e.Source.Path)
+			for i := range e.Appended {
+				e.Appended[i].Path = move(e.Appended[i].Path)
+			}
+		}
+		return nil
+	})
+}
+
+//update applies change to the entries saved in the manifest file and saves them. Other processes building shards
+//of the store save it too, so the file is locked and read again first and entries recorded by them are kept.
+func (m *Manifest) update(change func(entries map[string]*ManifestEntry) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return withLock(m.path, func() error {
+		entries, err := readManifest(m.path)
+		if err != nil {
+			return err
+		}
+		if err := change(entries); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(&Manifest{Entries: entries}, "", "\t")
+		if err != nil {
+			return err
+		}
+		if err := replaceFile(m.path, append(data, '\n')); err != nil {
+			return err
+		}
+		m.Entries = entries
+		return nil
+	})
+}
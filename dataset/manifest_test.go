@@ -0,0 +1,130 @@
+package cookieDb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	source := dir + "/foo_2016111100.log"
+	shardName := source + ".Intersection.gob"
+	if err := os.WriteFile(source, []byte(LINE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := CurrentOptions(SkipBadLines)
+	m, err := LoadManifest(ManifestPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make(Intersection)
+	if err := WriteShard(shardName, &d); err != nil {
+		t.Fatal(err)
+	}
+	if fresh, err := m.Fresh(shardName, "Intersection", source, opts); fresh || err != nil {
+		t.Error("shard without an entry is fresh", err)
+	}
+	if err := m.Record(shardName, "Intersection", source, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = LoadManifest(ManifestPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh, err := m.Fresh(shardName, "Intersection", source, opts); !fresh || err != nil {
+		t.Error("shard is stale after it was recorded", err)
+	}
+	if fresh, _ := m.Fresh(shardName, "StatSet", source, opts); fresh {
+		t.Error("shard of another type is fresh")
+	}
+	other := opts
+	other.Stamps = UnixMillis.String()
+	if fresh, _ := m.Fresh(shardName, "Intersection", source, other); fresh {
+		t.Error("shard built with other options is fresh")
+	}
+
+	//touching the source keeps the shard, changing it does not
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(source, later, later)
+	if fresh, err := m.Fresh(shardName, "Intersection", source, opts); !fresh || err != nil {
+		t.Error("touched source made the shard stale", err)
+	}
+	if !m.Entry(shardName).Source.ModTime.Equal(later) {
+		t.Error("entry does not have the new mtime", m.Entry(shardName).Source)
+	}
+	if err := os.WriteFile(source, []byte(LINE[:len(LINE)-1]+"8"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(source, later, later.Add(time.Minute))
+	if fresh, _ := m.Fresh(shardName, "Intersection", source, opts); fresh {
+		t.Error("changed source kept the shard")
+	}
+
+	os.Remove(shardName)
+	if fresh, _ := m.Fresh(shardName, "Intersection", source, opts); fresh {
+		t.Error("missing shard is fresh")
+	}
+
+	//entries used to be kept under the path of the shard, which is gone once the store is moved
+	legacy := `{"shards": {"/elsewhere/store/foo_2016111100.log.StatSet.gob": {"type": "StatSet"}}}`
+	if err := os.WriteFile(ManifestPath(dir), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = LoadManifest(ManifestPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Entry(dir + "/foo_2016111100.log.StatSet.gob"); e == nil || e.Type != "StatSet" {
+		t.Error("entry kept under the path of its shard is lost", m.Entries)
+	}
+}
+
+func TestManifestConcurrentBuilders(t *testing.T) {
+	dir := t.TempDir()
+	sources := []string{dir + "/foo_2016111100.log", dir + "/foo_2016111101.log"}
+	var manifests []*Manifest
+	for _, source := range sources {
+		if err := os.WriteFile(source, []byte(LINE), 0644); err != nil {
+			t.Fatal(err)
+		}
+		//every builder loads the manifest before the others recorded anything
+		m, err := LoadManifest(ManifestPath(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests = append(manifests, m)
+	}
+	opts := CurrentOptions(SkipBadLines)
+	for i, m := range manifests {
+		if err := m.Record(sources[i]+".Intersection.gob", "Intersection", sources[i], opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manifests[0].RecordAppend(sources[1]+".Intersection.gob", sources[0]); err != nil {
+		t.Fatal(err)
+	}
+	//the same late input is not folded in twice
+	if appended, err := manifests[1].Appended(sources[1]+".Intersection.gob", sources[0]); !appended || err != nil {
+		t.Error("appended input is not known to the other builder", err)
+	}
+	if err := manifests[1].RecordAppend(sources[1]+".Intersection.gob", sources[0]); !errors.Is(err, ErrAlreadyAppended) {
+		t.Error("input appended twice", err)
+	}
+
+	m, err := LoadManifest(ManifestPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 2 {
+		t.Error("entries of another builder are lost", m.Entries)
+	}
+	if e := m.Entry(sources[1] + ".Intersection.gob"); e == nil || len(e.Appended) != 1 {
+		t.Error("appended input is lost", e)
+	}
+	if matches, _ := filepath.Glob(dir + "/*.tmp"); len(matches) != 0 {
+		t.Error("temporary files are left", matches)
+	}
+}
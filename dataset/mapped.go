@@ -0,0 +1,152 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+//MapIndexed makes ReadShard map indexed shard files into memory as a MappedShard instead of opening an IndexedShard
+var MapIndexed = false
+
+//MappedShard is a read only Shard over an indexed shard file that is mapped into memory. Lookups binary search
+//the index and decode only the record they need, the pages of the file are shared with every process that maps it.
+//It is safe for concurrent readers.
+type MappedShard struct {
+	data     []byte
+	header   *Header
+	index    []indexEntry
+	indexOff int64
+}
+
+//OpenMappedShard maps the indexed shard file fileName into memory
+func OpenMappedShard(fileName string) (*MappedShard, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(len(indexedMagic))+8 {
+		return nil, fmt.Errorf("%s: not an indexed shard", fileName)
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	s := &MappedShard{data: data}
+	if err := s.parse(); err != nil {
+		unmapFile(data)
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return s, nil
+}
+
+func (s *MappedShard) parse() error {
+	r := bufio.NewReader(bytes.NewReader(s.data))
+	if !isIndexed(r) {
+		return errors.New("not an indexed shard")
+	}
+	var err error
+	if s.header, err = readIndexedHeader(r); err != nil {
+		return err
+	}
+	footer := int64(len(s.data)) - 8 - s.header.footerSize()
+	if footer < 0 {
+		return errors.New("file is truncated")
+	}
+	s.indexOff = int64(binary.LittleEndian.Uint64(s.data[footer:]))
+	if s.indexOff < 0 || s.indexOff > footer {
+		return errors.New("index offset is out of the file")
+	}
+	s.index, err = parseIndex(s.data[s.indexOff:footer])
+	return err
+}
+
+//Header returns the header of the shard
+func (s *MappedShard) Header() *Header {
+	return s.header
+}
+
+//Close unmaps the shard file, the Cookies returned before stay valid
+func (s *MappedShard) Close() error {
+	if s.data == nil {
+		return nil
+	}
+	err := unmapFile(s.data)
+	s.data = nil
+	s.index = nil
+	return err
+}
+
+func (s *MappedShard) Add(line []byte, fileName string) error {
+	return errReadOnly
+}
+
+func (s *MappedShard) Size() int {
+	return s.header.Count
+}
+
+//Init unmaps the shard file and leaves an empty shard
+func (s *MappedShard) Init() {
+	s.Close()
+	s.header = &Header{Version: FormatVersion, Type: s.header.Type}
+}
+
+func (s *MappedShard) Type() string {
+	return s.header.Type
+}
+
+//GetElems decodes nr cookies of the shard like IndexedShard.GetElems, from a random one on
+func (s *MappedShard) GetElems(nr int) []Cookie {
+	ret := make([]Cookie, 0, nr)
+	if len(s.index) == 0 {
+		return ret
+	}
+	start := sampleStart(nr, s.Size())
+	ret = s.elems(ret, start, start+nr)
+	if start > 0 {
+		ret = s.elems(ret, 0, nr-len(ret))
+	}
+	return ret
+}
+
+//elems appends the cookies of the records from up to to to ret
+func (s *MappedShard) elems(ret []Cookie, from, to int) []Cookie {
+	if from >= to {
+		return ret
+	}
+	rr := &recReader{buf: s.data[s.index[from/indexInterval].offset:s.indexOff]}
+	for i := from - from%indexInterval; i < to && len(rr.buf) > 0 && rr.err == nil; i++ {
+		id, value := rr.str(), rr.bytes()
+		if i < from {
+			continue
+		}
+		c, err := decodeValue(s.header.Type, id, value)
+		if err != nil {
+			break
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+//Get decodes the record of cookieID straight from the mapped file
+func (s *MappedShard) Get(cookieID string) Cookie {
+	start, end, ok := blockOf(s.index, s.indexOff, cookieID)
+	if !ok {
+		return nil
+	}
+	return findInBlock(s.data[start:end], s.header.Type, cookieID)
+}
+
+//Load decodes every cookie of the shard into the Shard type it was written from
+func (s *MappedShard) Load() (Shard, error) {
+	return loadCookies(s.header.Type, s.GetElems(s.Size()), s.Size())
+}
@@ -0,0 +1,70 @@
+package cookieDb
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMappedShard(t *testing.T) {
+	dir := t.TempDir()
+	d := make(StatSet)
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(manyCookies(300))), &d, "test_2016111100.log", nil); err != nil {
+		t.Fatal(err)
+	}
+	shardName := dir + "/test_2016111100.log.StatSet.gob"
+	if err := WriteIndexedShard(shardName, &d); err != nil {
+		t.Fatal(err)
+	}
+	MapIndexed = true
+	defer func() { MapIndexed = false }()
+	s, err := ReadShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := s.(*MappedShard)
+	if !ok || m.Size() != 300 || m.Type() != "StatSet" {
+		t.Fatalf("wrong shard %T", s)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range d.GetElems(d.Size()) {
+				if got := m.Get(c.ID()); got == nil || !sameCookie(got, c) {
+					t.Errorf("%s does not read back: %v", c.ID(), got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if m.Get("cookie0150x") != nil || m.Get("a") != nil {
+		t.Error("found a missing cookie")
+	}
+	checkSamples(t, m)
+
+	c := m.Get("cookie0001")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID() != "cookie0001" || c.Count() != 1 {
+		t.Error("cookie is not valid after close")
+	}
+	if m.Get("cookie0001") != nil {
+		t.Error("closed shard still finds cookies")
+	}
+
+	if _, err := AppendShard(shardName, "late_2016111100.log", bufio.NewScanner(strings.NewReader("late\t1478840400:3")), nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err = OpenMappedShard(shardName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Size() != 301 || m.Get("late") == nil {
+		t.Error("append to a mapped shard is lost")
+	}
+}
@@ -0,0 +1,51 @@
+package cookieDb
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+//Meta describes how a shard was built
+type Meta struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Timezone string `json:"timezone"`
+	//Sources are the logs of a shard merged by Store.Compact, Source is empty then
+	Sources []string `json:"sources,omitempty"`
+}
+
+//MetaPath returns the file next to shardName that holds its Meta
+func MetaPath(shardName string) string {
+	return strings.TrimSuffix(shardName, ".gob") + ".meta.json"
+}
+
+//ReadMeta reads the Meta of shardName. Shards built before metadata was written are in the zone of LOC,
+//which is DefaultTimezone unless it failed to load.
+func ReadMeta(shardName string) (*Meta, error) {
+	f, err := os.Open(MetaPath(shardName))
+	if os.IsNotExist(err) {
+		return &Meta{Timezone: LOC.String()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := new(Meta)
+	return m, json.NewDecoder(f).Decode(m)
+}
+
+//WriteMeta writes m as the Meta of shardName
+func WriteMeta(shardName string, m *Meta) error {
+	f, err := os.Create(MetaPath(shardName))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
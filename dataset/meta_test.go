@@ -0,0 +1,18 @@
+package cookieDb
+
+import "testing"
+
+func TestMeta(t *testing.T) {
+	shardName := t.TempDir() + "/foo_2016111100.log.StatSet.gob"
+	m, err := ReadMeta(shardName)
+	if err != nil || m.Timezone != LOC.String() {
+		t.Error("shard without metadata is not in the default timezone", m, err)
+	}
+	if err := WriteMeta(shardName, &Meta{Type: "StatSet", Source: "foo_2016111100.log", Timezone: "Europe/Amsterdam"}); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ReadMeta(shardName)
+	if err != nil || m.Timezone != "Europe/Amsterdam" || m.Type != "StatSet" {
+		t.Error("meta does not round trip", m, err)
+	}
+}
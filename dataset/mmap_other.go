@@ -0,0 +1,19 @@
+//go:build !unix
+
+package cookieDb
+
+import (
+	"io"
+	"os"
+)
+
+//mapFile reads the size bytes of f, on this platform shards are not shared between processes
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	return data, err
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,177 @@
+package cookieDb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//Reason classifies why a line could not be parsed
+type Reason string
+
+const (
+	ReasonMissingFields     Reason = "missing_fields"
+	ReasonMissingCategories Reason = "missing_categories"
+	ReasonBadTimestamp      Reason = "bad_timestamp"
+	ReasonEmptyCookieID     Reason = "empty_cookie_id"
+	ReasonBadRecord         Reason = "bad_record"
+	ReasonOther             Reason = "other"
+)
+
+//ParseError describes a line of input that a Shard refused to add
+type ParseError struct {
+	File   string
+	Line   int
+	Offset int64
+	Reason Reason
+	Err    error
+}
+
+func newParseError(reason Reason, err error) *ParseError {
+	return &ParseError{Reason: reason, Err: err}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d (offset %d): %s: %v", e.File, e.Line, e.Offset, e.Reason, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//BadLinePolicy decides what FillDb does with a line that fails to parse
+type BadLinePolicy int
+
+const (
+	//SkipBadLines drops the line, counts it in the Report and carries on
+	SkipBadLines BadLinePolicy = iota
+	//AbortOnBadLine stops the fill and returns the ParseError
+	AbortOnBadLine
+	//QuarantineBadLines writes the line verbatim to FillOptions.Quarantine and carries on
+	QuarantineBadLines
+)
+
+var policyNames = map[BadLinePolicy]string{
+	SkipBadLines:       "skip",
+	AbortOnBadLine:     "abort",
+	QuarantineBadLines: "quarantine",
+}
+
+func (p BadLinePolicy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("BadLinePolicy(%d)", int(p))
+}
+
+//ParsePolicy returns the policy named by s, one of skip, abort or quarantine
+func ParsePolicy(s string) (BadLinePolicy, error) {
+	for p, name := range policyNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return SkipBadLines, fmt.Errorf("unknown bad line policy %q", s)
+}
+
+//FillOptions configures FillDb, the zero value skips bad lines
+type FillOptions struct {
+	Policy     BadLinePolicy
+	Quarantine io.Writer
+	//Erased are the cookies erased from the store the shard is in, their lines are left out
+	Erased ErasedIDs
+}
+
+//Report sums up what FillDb did with one input file
+type Report struct {
+	File       string         `json:"file"`
+	Lines      int            `json:"lines"`
+	Rejected   int            `json:"rejected"`
+	Reasons    map[Reason]int `json:"reasons,omitempty"`
+	Quarantine string         `json:"quarantine,omitempty"`
+	//Erased counts the lines of erased cookies that were left out
+	Erased int `json:"erased,omitempty"`
+}
+
+func (r *Report) reject(perr *ParseError) {
+	r.Rejected++
+	if r.Reasons == nil {
+		r.Reasons = make(map[Reason]int)
+	}
+	r.Reasons[perr.Reason]++
+}
+
+//add hands one line to d, the returned error means the fill has to stop
+func (r *Report) add(d Shard, line []byte, fileName string, offset int64, opts *FillOptions) error {
+	r.Lines++
+	if len(opts.Erased) > 0 {
+		if id, err := DecoderFor(fileName).ID(line); err == nil && opts.Erased.Has(id) {
+			r.Erased++
+			return nil
+		}
+	}
+	err := d.Add(line, fileName)
+	if err == nil {
+		return nil
+	}
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		perr = newParseError(ReasonOther, err)
+	}
+	perr.File, perr.Line, perr.Offset = fileName, r.Lines, offset
+	r.reject(perr)
+	switch opts.Policy {
+	case AbortOnBadLine:
+		return perr
+	case QuarantineBadLines:
+		if _, err := fmt.Fprintf(opts.Quarantine, "%s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Report) finish(opts *FillOptions) {
+	if q, ok := opts.Quarantine.(*Quarantine); ok && opts.Policy == QuarantineBadLines && r.Rejected > 0 {
+		r.Quarantine = q.Path()
+	}
+}
+
+func checkFillOptions(opts *FillOptions) (*FillOptions, error) {
+	if opts == nil {
+		opts = &FillOptions{}
+	}
+	if opts.Policy == QuarantineBadLines && opts.Quarantine == nil {
+		return opts, errors.New("quarantine policy needs a quarantine writer")
+	}
+	return opts, nil
+}
+
+//FillDb adds every line read by scanner to d, handling lines that d refuses according to opts.
+//A nil opts is the same as the zero FillOptions.
+func FillDb(scanner *bufio.Scanner, d Shard, fileName string, opts *FillOptions) (Shard, *Report, error) {
+	opts, err := checkFillOptions(opts)
+	if err != nil {
+		return d, nil, err
+	}
+	report := &Report{File: fileName}
+	var offset, next int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		next += int64(advance)
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		if err := report.add(d, scanner.Bytes(), fileName, offset, opts); err != nil {
+			return d, report, err
+		}
+		offset = next
+	}
+	report.finish(opts)
+	if err := scanner.Err(); err != nil {
+		return d, report, fmt.Errorf("reading %s: %v", fileName, err)
+	}
+	return d, report, nil
+}
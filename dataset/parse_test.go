@@ -0,0 +1,73 @@
+package cookieDb
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var BADLINES = strings.Join([]string{
+	"goodCookie1\t1480551255:3,17",
+	"noTabHere",
+	"\t1480551255:3",
+	"badStamp\tnotanumber:3,17",
+	"noColon\t1480551255",
+	"goodCookie2\t1480551255:3;1480551256:4",
+}, "\n")
+
+func TestFillDbPolicies(t *testing.T) {
+	//Intersection only reads the cookie id, so it accepts lines with broken events
+	testCases := []struct {
+		newShard func() Shard
+		rejected int
+	}{
+		{func() Shard { d := make(Intersection); return &d }, 2},
+		{func() Shard { d := make(CountTimeSet); return &d }, 4},
+		{func() Shard { d := make(CountTimeCatsSet); return &d }, 4},
+		{func() Shard { d := make(StatSet); return &d }, 4},
+	}
+	for _, test := range testCases {
+		newShard := test.newShard
+		d, report, err := FillDb(bufio.NewScanner(strings.NewReader(BADLINES)), newShard(), "test_2016111100.log", nil)
+		if err != nil {
+			t.Fatal(d.Type(), err)
+		}
+		if report.Lines != 6 || report.Rejected != test.rejected {
+			t.Error(d.Type(), "wrong report", report)
+		}
+		if d.Size() != 6-test.rejected {
+			t.Error(d.Type(), "wrong number of cookies", d.Size())
+		}
+
+		_, report, err = FillDb(bufio.NewScanner(strings.NewReader(BADLINES)), newShard(), "test_2016111100.log", &FillOptions{Policy: AbortOnBadLine})
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatal(d.Type(), "expected a ParseError, got", err)
+		}
+		if perr.Line != 2 || perr.Offset != 28 || perr.Reason != ReasonMissingFields || report.Lines != 2 {
+			t.Error(d.Type(), "wrong parse error", perr)
+		}
+
+		quarantine := new(bytes.Buffer)
+		_, report, err = FillDb(bufio.NewScanner(strings.NewReader(BADLINES)), newShard(), "test_2016111100.log", &FillOptions{Policy: QuarantineBadLines, Quarantine: quarantine})
+		if err != nil {
+			t.Fatal(d.Type(), err)
+		}
+		if strings.Count(quarantine.String(), "\n") != test.rejected || !strings.HasPrefix(quarantine.String(), "noTabHere\n\t1480551255:3\n") {
+			t.Errorf("%s: wrong quarantine %q", d.Type(), quarantine)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []BadLinePolicy{SkipBadLines, AbortOnBadLine, QuarantineBadLines} {
+		got, err := ParsePolicy(p.String())
+		if err != nil || got != p {
+			t.Error("policy does not round trip", p, got, err)
+		}
+	}
+	if _, err := ParsePolicy("ignore"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
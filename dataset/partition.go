@@ -0,0 +1,318 @@
+package cookieDb
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Partitions lays a dataset out in shards, or partitions, that each hold the whole history of the cookies whose id
+//hashes to them, instead of one shard per hour. A cookie is read from one partition, a time range through the
+//TimeIndex of every partition.
+type Partitions struct {
+	Dir      string      `json:"-"`
+	Type     string      `json:"type"`
+	Timezone string      `json:"timezone"`
+	Parts    []Partition `json:"partitions"`
+	//Shards are the shards the partitions were built from
+	Shards  []string  `json:"shards"`
+	BuiltAt time.Time `json:"builtAt"`
+}
+
+//Partition describes one partition file of Partitions
+type Partition struct {
+	File  string    `json:"file"`
+	Count int       `json:"count"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+//TimeIndex lists the cookies of a partition that have events in each hour, sorted by hour
+type TimeIndex struct {
+	Hours []time.Time
+	IDs   [][]string
+}
+
+//PartitionOf returns the partition of n that holds the cookie id. The hash is FNV-32a, which does not change
+//between builds, so partitions written by one build are read by the next.
+func PartitionOf(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(n))
+}
+
+//PartitionsPath returns the file in dir that describes its partitions
+func PartitionsPath(dir string) string {
+	return filepath.Join(dir, "partitions.json")
+}
+
+//TimeIndexPath returns the file next to partName that holds its TimeIndex
+func TimeIndexPath(partName string) string {
+	return strings.TrimSuffix(partName, ".gob") + ".times"
+}
+
+//OpenPartitions reads the partitions described in dir
+func OpenPartitions(dir string) (*Partitions, error) {
+	f, err := os.Open(PartitionsPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &Partitions{Dir: dir}
+	return p, json.NewDecoder(f).Decode(p)
+}
+
+//Stale tells if the partitions have to be rebuilt to hold shards in n partitions,
+//because the shards or n changed or a shard was written after the partitions were
+func (p *Partitions) Stale(shards []string, n int) bool {
+	if len(p.Parts) != n || len(p.Shards) != len(shards) {
+		return true
+	}
+	for i, shard := range shards {
+		info, err := os.Stat(shard)
+		if shard != p.Shards[i] || err != nil || info.ModTime().After(p.BuiltAt) {
+			return true
+		}
+	}
+	return false
+}
+
+//Of returns the partition that holds the cookie id
+func (p *Partitions) Of(id string) int {
+	return PartitionOf(id, len(p.Parts))
+}
+
+//Path returns the file of partition i
+func (p *Partitions) Path(i int) string {
+	return filepath.Join(p.Dir, p.Parts[i].File)
+}
+
+//Get reads the cookie id from its partition, it is nil when no shard held it
+func (p *Partitions) Get(id string) (Cookie, error) {
+	d, err := ReadShard(p.Path(p.Of(id)))
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := d.(readOnlyShard); ok {
+		defer s.Close()
+	}
+	return d.Get(id), nil
+}
+
+//ReadTimeIndex reads the TimeIndex of partition i
+func (p *Partitions) ReadTimeIndex(i int) (*TimeIndex, error) {
+	f, err := os.Open(TimeIndexPath(p.Path(i)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx := new(TimeIndex)
+	return idx, gob.NewDecoder(f).Decode(idx)
+}
+
+//Range returns the cookies that have an event in [from, to), with their whole history.
+//Only the partitions whose TimeIndex has such a cookie are read.
+func (p *Partitions) Range(from, to time.Time) ([]Cookie, error) {
+	var cookies []Cookie
+	for i, part := range p.Parts {
+		if part.Count == 0 || part.End.Before(from) || !part.Start.Before(to) {
+			continue
+		}
+		idx, err := p.ReadTimeIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		ids := idx.Range(from, to)
+		if len(ids) == 0 {
+			continue
+		}
+		d, err := ReadShard(p.Path(i))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if c := d.Get(id); c != nil {
+				cookies = append(cookies, c)
+			}
+		}
+		if s, ok := d.(readOnlyShard); ok {
+			s.Close()
+		}
+	}
+	return cookies, nil
+}
+
+//Range returns the ids of the cookies with events in the hours that overlap [from, to), each id once
+func (idx *TimeIndex) Range(from, to time.Time) []string {
+	start := sort.Search(len(idx.Hours), func(i int) bool { return idx.Hours[i].Add(time.Hour).After(from) })
+	seen := make(map[string]bool)
+	var ids []string
+	for i := start; i < len(idx.Hours) && idx.Hours[i].Before(to); i++ {
+		for _, id := range idx.IDs[i] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func newTimeIndex(d Shard) *TimeIndex {
+	hours := make(map[int64][]string)
+	for _, c := range d.GetElems(d.Size()) {
+		seen := make(map[int64]bool)
+		for _, t := range c.Time() {
+			hour := t.Truncate(time.Hour).Unix()
+			if !seen[hour] {
+				seen[hour] = true
+				hours[hour] = append(hours[hour], c.ID())
+			}
+		}
+	}
+	idx := new(TimeIndex)
+	for hour := range hours {
+		idx.Hours = append(idx.Hours, time.Unix(hour, 0))
+	}
+	sort.Slice(idx.Hours, func(i, j int) bool { return idx.Hours[i].Before(idx.Hours[j]) })
+	for _, hour := range idx.Hours {
+		ids := hours[hour.Unix()]
+		sort.Strings(ids)
+		idx.IDs = append(idx.IDs, ids)
+	}
+	return idx
+}
+
+func writeTimeIndex(partName string, idx *TimeIndex) error {
+	path := TimeIndexPath(partName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//splitShard divides the cookies of d over n shards of its type by PartitionOf
+func splitShard(d Shard, n int) ([]Shard, error) {
+	parts := make([]Shard, n)
+	for i := range parts {
+		var err error
+		if parts[i], err = newShard(d.Type()); err != nil {
+			return nil, err
+		}
+	}
+	switch d := d.(type) {
+	case *Intersection:
+		for id, c := range *d {
+			(*parts[PartitionOf(id, n)].(*Intersection))[id] = c
+		}
+	case *CountTimeSet:
+		for id, c := range *d {
+			(*parts[PartitionOf(id, n)].(*CountTimeSet))[id] = c
+		}
+	case *CountTimeCatsSet:
+		for id, c := range *d {
+			(*parts[PartitionOf(id, n)].(*CountTimeCatsSet))[id] = c
+		}
+	case *StatSet:
+		for id, u := range *d {
+			(*parts[PartitionOf(id, n)].(*StatSet))[id] = u
+		}
+	default:
+		return nil, fmt.Errorf("%s can not be partitioned", d.Type())
+	}
+	return parts, nil
+}
+
+//PartitionShards re-partitions the shards in names, which must be of one type and timezone, into n partitions
+//written to dir, in the indexed format when indexed is set. The cookies of a partition are merged the way
+//CompactShards merges them. The description of the partitions is written last, when all partitions are in place.
+func PartitionShards(dir string, n int, names []string, indexed bool) (*Partitions, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("can not partition into %d partitions", n)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no shards to partition")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var parts []Shard
+	p := &Partitions{Dir: dir, Shards: names, BuiltAt: time.Now()}
+	for _, name := range names {
+		d, h, err := loadShard(name)
+		if err != nil {
+			return nil, err
+		}
+		if parts == nil {
+			p.Type, p.Timezone = h.Type, h.Timezone
+		} else if h.Type != p.Type || h.Timezone != p.Timezone {
+			return nil, fmt.Errorf("%s is a %s in %s, the other shards are a %s in %s", name, h.Type, h.Timezone, p.Type, p.Timezone)
+		}
+		split, err := splitShard(d, n)
+		if err != nil {
+			return nil, err
+		}
+		if parts == nil {
+			parts = split
+			continue
+		}
+		for i := range parts {
+			if err := mergeShard(parts[i], split[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	write := writeShard
+	if indexed {
+		write = writeIndexedShard
+	}
+	for i, d := range parts {
+		part := Partition{File: fmt.Sprintf("part-%04d.%s.gob", i, p.Type)}
+		partName := filepath.Join(dir, part.File)
+		h := newHeader(d, p.Timezone)
+		h.Replaces = names
+		if err := replaceShard(partName, d, func(tmp string) error { return write(tmp, d, h) }); err != nil {
+			return nil, err
+		}
+		if err := writeTimeIndex(partName, newTimeIndex(d)); err != nil {
+			return nil, err
+		}
+		part.Count, part.Start, part.End = h.Count, h.Start, h.End
+		p.Parts = append(p.Parts, part)
+	}
+	return p, p.save()
+}
+
+func (p *Partitions) save() error {
+	path := PartitionsPath(p.Dir)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(p); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
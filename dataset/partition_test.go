@@ -0,0 +1,66 @@
+package cookieDb
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionShards(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i, input := range []string{LINE, LINE + "\nnewCookie\t1478840501:4\notherCookie\t1478844000:5"} {
+		shardName := fmt.Sprintf("%s/foo_201611110%d.log.StatSet.gob", dir, i)
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), &StatSet{}, shardName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteShard(shardName, d); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, shardName)
+	}
+	p, err := PartitionShards(dir+"/partitions", 4, names, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = OpenPartitions(dir + "/partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Stale(names, 4) || !p.Stale(names, 3) || !p.Stale(names[:1], 4) {
+		t.Error("wrong staleness")
+	}
+	total := 0
+	for _, part := range p.Parts {
+		total += part.Count
+	}
+	if total != 3 {
+		t.Error("partitions hold", total, "cookies instead of 3")
+	}
+	c, err := p.Get("BhrVPRR199e9aC8R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Count() != 2 {
+		t.Error("history of the cookie is not in its partition", c)
+	}
+	for _, id := range []string{"newCookie", "otherCookie"} {
+		d, err := ReadShard(p.Path(PartitionOf(id, 4)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Get(id) == nil {
+			t.Error(id, "is not in the partition it hashes to")
+		}
+	}
+	cookies, err := p.Range(time.Unix(1478840400, 0), time.Unix(1478844000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 1 || cookies[0].ID() != "newCookie" {
+		t.Error("wrong cookies in the time range", cookies)
+	}
+}
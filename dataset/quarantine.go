@@ -0,0 +1,79 @@
+package cookieDb
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+//QuarantinePath returns the file next to shardName that holds the lines rejected while building it
+func QuarantinePath(shardName string) string {
+	return strings.TrimSuffix(shardName, ".gob") + ".rejected"
+}
+
+//ReportPath returns the file next to shardName that holds the Report of its build
+func ReportPath(shardName string) string {
+	return strings.TrimSuffix(shardName, ".gob") + ".report.json"
+}
+
+//Quarantine is an io.Writer that only creates its file once a rejected line is written to it
+type Quarantine struct {
+	path string
+	f    *os.File
+}
+
+func NewQuarantine(path string) *Quarantine {
+	return &Quarantine{path: path}
+}
+
+func (q *Quarantine) Path() string {
+	return q.path
+}
+
+func (q *Quarantine) Write(p []byte) (int, error) {
+	if q.f == nil {
+		f, err := os.Create(q.path)
+		if err != nil {
+			return 0, err
+		}
+		q.f = f
+	}
+	return q.f.Write(p)
+}
+
+//Close closes the quarantine file, if nothing was rejected a stale file from an earlier build is removed
+func (q *Quarantine) Close() error {
+	if q.f == nil {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return q.f.Close()
+}
+
+//ReadReport reads a Report written by WriteFile
+func ReadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := new(Report)
+	return r, json.NewDecoder(f).Decode(r)
+}
+
+//WriteFile writes the report as json to path
+func (r *Report) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
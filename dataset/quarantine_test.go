@@ -0,0 +1,52 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQuarantineReport(t *testing.T) {
+	dir := t.TempDir()
+	shardName := dir + "/test_2016111100.log.StatSet.gob"
+	q := NewQuarantine(QuarantinePath(shardName))
+	d := make(StatSet)
+	_, report, err := FillDb(bufio.NewScanner(strings.NewReader(BADLINES)), &d, "test_2016111100.log", &FillOptions{Policy: QuarantineBadLines, Quarantine: q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if report.Quarantine != dir+"/test_2016111100.log.StatSet.rejected" {
+		t.Error("report does not point at the quarantine", report.Quarantine)
+	}
+	if err := report.WriteFile(ReportPath(shardName)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ReadReport(ReportPath(shardName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Lines != 6 || r.Rejected != 4 || r.Reasons[ReasonBadTimestamp] != 1 || r.Reasons[ReasonMissingCategories] != 1 {
+		t.Error("wrong report", r)
+	}
+	rejected, err := os.ReadFile(report.Quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(rejected), "\n") != 4 {
+		t.Errorf("wrong quarantine %q", rejected)
+	}
+
+	q = NewQuarantine(QuarantinePath(shardName))
+	d.Init()
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &d, "test_2016111100.log", &FillOptions{Policy: QuarantineBadLines, Quarantine: q}); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if _, err := os.Stat(QuarantinePath(shardName)); !os.IsNotExist(err) {
+		t.Error("stale quarantine was not removed", err)
+	}
+}
@@ -0,0 +1,298 @@
+package cookieDb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Retention is how long the events of each shard type are kept, the "*" entry holds for the types without one.
+//Types without an entry are kept forever.
+type Retention map[string]time.Duration
+
+//RetentionPath returns the file in the store dir that holds its Retention
+func RetentionPath(dir string) string {
+	return filepath.Join(dir, "retention.json")
+}
+
+//LoadRetention reads the Retention at path, a json object of shard types and ages like "720h" or "30d".
+//A missing file keeps everything.
+func LoadRetention(path string) (Retention, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Retention{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ages map[string]string
+	if err := json.NewDecoder(f).Decode(&ages); err != nil {
+		return nil, err
+	}
+	r := make(Retention)
+	for shardType, age := range ages {
+		if r[shardType], err = parseAge(age); err != nil {
+			return nil, fmt.Errorf("%s: retention of %s: %v", path, shardType, err)
+		}
+	}
+	return r, nil
+}
+
+//parseAge parses a time.Duration, or a number of days ending in d
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+//Cutoff returns the time before which events of shardType expire at now, false when they never do
+func (r Retention) Cutoff(shardType string, now time.Time) (time.Time, bool) {
+	age, ok := r[shardType]
+	if !ok {
+		age, ok = r["*"]
+	}
+	if !ok || age <= 0 {
+		return time.Time{}, false
+	}
+	return now.Add(-age), true
+}
+
+//GCReport lists what Store.GC removed
+type GCReport struct {
+	Dropped []string
+	Pruned  []string
+	//Events and Cookies count what was pruned from the shards that were kept
+	Events  int
+	Cookies int
+}
+
+//GC enforces r on the shards and the partitions of the store at now. Shards whose time range ended before the
+//cutoff of their type are deleted, shards and partitions that only started before it have the expired events
+//pruned and are rewritten. Cookies left without events are dropped. The counts of CountTimeSet and
+//CountTimeCatsSet cookies are capped at their remaining timestamps, and their categories, which are not tied to a
+//timestamp, are kept while any timestamp is.
+//Intersection shards have no times and expire with the hour of their source, merged ones with the latest hour.
+func (s *Store) GC(r Retention, now time.Time) (*GCReport, error) {
+	report := new(GCReport)
+	for _, e := range s.List("") {
+		drop, prune, cutoff := expiry(r, now, e.Type, e.Start, e.End, e.sources())
+		switch {
+		case drop:
+			if err := s.remove(e); err != nil {
+				return report, err
+			}
+			if err := s.save(); err != nil {
+				return report, err
+			}
+			report.Dropped = append(report.Dropped, e.File)
+		case prune:
+			events, cookies, err := s.prune(e, cutoff)
+			if err != nil {
+				return report, fmt.Errorf("%s: %v", e.File, err)
+			}
+			report.Pruned = append(report.Pruned, e.File)
+			report.Events += events
+			report.Cookies += cookies
+		}
+	}
+	p, err := OpenPartitions(s.PartitionsDir())
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	if cutoff, ok := r.Cutoff(p.Type, now); ok {
+		err = p.prune(cutoff, report)
+	}
+	return report, err
+}
+
+//GCShards enforces r at now on shard files outside a store, like those CompactShards merged elsewhere, the way GC
+//does on the shards of a store. Intersection shards expire with the latest hour of the shards they replace.
+func GCShards(names []string, r Retention, now time.Time) (*GCReport, error) {
+	report := new(GCReport)
+	for _, name := range names {
+		h, err := ReadHeader(name)
+		if err != nil {
+			return report, fmt.Errorf("%s: %v", name, err)
+		}
+		sources := h.Replaces
+		if len(sources) == 0 {
+			sources = []string{name}
+		}
+		drop, prune, cutoff := expiry(r, now, h.Type, h.Start, h.End, sources)
+		switch {
+		case drop:
+			if err := RemoveShard(name); err != nil {
+				return report, err
+			}
+			report.Dropped = append(report.Dropped, name)
+		case prune:
+			_, _, events, cookies, err := pruneFile(name, cutoff)
+			if err != nil {
+				return report, fmt.Errorf("%s: %v", name, err)
+			}
+			report.Pruned = append(report.Pruned, name)
+			report.Events += events
+			report.Cookies += cookies
+		}
+	}
+	return report, nil
+}
+
+//expiry tells if r drops a shard of shardType with events from start to end at now, or prunes its events before
+//cutoff. When it has no times it ends with the latest hour of the files in sources, it is kept when one has none.
+func expiry(r Retention, now time.Time, shardType string, start, end time.Time, sources []string) (drop, prune bool, cutoff time.Time) {
+	cutoff, ok := r.Cutoff(shardType, now)
+	if !ok {
+		return false, false, cutoff
+	}
+	if end.IsZero() {
+		for _, source := range sources {
+			t, err := FileTimes.Extract(source)
+			if err != nil {
+				return false, false, cutoff
+			}
+			if t = t.Add(time.Hour); t.After(end) {
+				end = t
+			}
+		}
+	}
+	if end.Before(cutoff) {
+		return true, false, cutoff
+	}
+	return false, !start.IsZero() && start.Before(cutoff), cutoff
+}
+
+//prune rewrites the shard of e without the events before cutoff and updates its catalog entry
+func (s *Store) prune(e *CatalogEntry, cutoff time.Time) (events, cookies int, err error) {
+	if _, _, events, cookies, err = pruneFile(s.File(e), cutoff); err != nil {
+		return 0, 0, err
+	}
+	_, err = s.update(e)
+	return events, cookies, err
+}
+
+//prune rewrites the partitions that hold events before cutoff without them, and their time index
+func (p *Partitions) prune(cutoff time.Time, report *GCReport) error {
+	changed := false
+	for i, part := range p.Parts {
+		if part.Start.IsZero() || !part.Start.Before(cutoff) {
+			continue
+		}
+		d, h, events, cookies, err := pruneFile(p.Path(i), cutoff)
+		if err != nil {
+			return fmt.Errorf("%s: %v", part.File, err)
+		}
+		if err := writeTimeIndex(p.Path(i), newTimeIndex(d)); err != nil {
+			return err
+		}
+		p.Parts[i].Count, p.Parts[i].Start, p.Parts[i].End = h.Count, h.Start, h.End
+		report.Pruned = append(report.Pruned, filepath.Join(filepath.Base(p.Dir), part.File))
+		report.Events += events
+		report.Cookies += cookies
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return p.save()
+}
+
+//pruneFile rewrites the shard file shardName without the events before cutoff, in the format it was in.
+//It returns the pruned shard and its new header.
+func pruneFile(shardName string, cutoff time.Time) (d Shard, h *Header, events, cookies int, err error) {
+	d, h, err = readShard(shardName)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	write := writeShard
+	if ro, ok := d.(readOnlyShard); ok {
+		d, err = ro.Load()
+		ro.Close()
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		write = writeIndexedShard
+	}
+	if events, cookies, err = pruneShard(d, cutoff); err != nil {
+		return nil, nil, 0, 0, err
+	}
+	h = h.rewrite(d)
+	return d, h, events, cookies, replaceShard(shardName, d, func(tmp string) error { return write(tmp, d, h) })
+}
+
+//pruneShard drops the events of d before cutoff and the cookies left without events, a cookie of a
+//CountTimeCatsSet that lost events loses all of its categories. It returns the number of events and cookies dropped.
+func pruneShard(d Shard, cutoff time.Time) (events, cookies int, err error) {
+	keep := func(times []time.Time) []time.Time {
+		kept := times[:0]
+		for _, t := range times {
+			if t.Before(cutoff) {
+				events++
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	}
+	switch set := d.(type) {
+	case *CountTimeSet:
+		for id, c := range *set {
+			if c.TStamp = keep(c.TStamp); len(c.TStamp) == 0 {
+				delete(*set, id)
+				cookies++
+			} else if c.Count > len(c.TStamp) {
+				c.Count = len(c.TStamp)
+			}
+		}
+	case *CountTimeCatsSet:
+		for id, c := range *set {
+			n := len(c.TStamp)
+			if c.TStamp = keep(c.TStamp); len(c.TStamp) == 0 {
+				delete(*set, id)
+				cookies++
+				continue
+			}
+			if c.Counter > len(c.TStamp) {
+				c.Counter = len(c.TStamp)
+			}
+			if len(c.TStamp) < n {
+				//the categories are not kept per event, the ones of the pruned events can not be told apart
+				c.Categories = nil
+			}
+		}
+	case *StatSet:
+		for id, u := range *set {
+			sessions := u.Sess[:0]
+			for _, sess := range u.Sess {
+				kept := sess.Events[:0]
+				for _, ev := range sess.Events {
+					if ev.T.Before(cutoff) {
+						events++
+					} else {
+						kept = append(kept, ev)
+					}
+				}
+				if sess.Events = kept; len(kept) > 0 {
+					sessions = append(sessions, sess)
+				}
+			}
+			if u.Sess = sessions; len(sessions) == 0 {
+				delete(*set, id)
+				cookies++
+			}
+		}
+	default:
+		return 0, 0, fmt.Errorf("events of a %s can not be pruned", d.Type())
+	}
+	return events, cookies, nil
+}
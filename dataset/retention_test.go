@@ -0,0 +1,160 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRetention(t *testing.T) {
+	dir := t.TempDir()
+	if r, err := LoadRetention(RetentionPath(dir)); err != nil || len(r) != 0 {
+		t.Error("missing config should keep everything", r, err)
+	}
+	os.WriteFile(RetentionPath(dir), []byte(`{"StatSet": "30d", "*": "2160h"}`), 0644)
+	r, err := LoadRetention(RetentionPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 12, 31, 0, 0, 0, 0, time.UTC)
+	if cutoff, ok := r.Cutoff("StatSet", now); !ok || !cutoff.Equal(now.AddDate(0, 0, -30)) {
+		t.Error("wrong cutoff", cutoff)
+	}
+	if cutoff, ok := r.Cutoff("Intersection", now); !ok || !cutoff.Equal(now.Add(-2160*time.Hour)) {
+		t.Error("wrong default cutoff", cutoff)
+	}
+	os.WriteFile(RetentionPath(dir), []byte(`{"StatSet": "a month"}`), 0644)
+	if _, err := LoadRetention(RetentionPath(dir)); err == nil {
+		t.Error("expected an error for a bad age")
+	}
+}
+
+func TestStoreGC(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string]string{
+		dir + "/foo_2016110100.log": "old\t1478000000:1",
+		dir + "/foo_2016120600.log": LINE + "\ngone\t1478000000:1",
+	}
+	for _, newShard := range []func() Shard{func() Shard { return &StatSet{} }, func() Shard { return &CountTimeSet{} }} {
+		for source, input := range inputs {
+			d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), newShard(), source, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Put(source, d, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var names []string
+	for source := range inputs {
+		names = append(names, s.Path(source, "StatSet"))
+	}
+	if _, err := PartitionShards(s.PartitionsDir(), 2, names, false); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 12, 16, 0, 0, 0, 0, time.UTC)
+	report, err := s.GC(Retention{"*": 20 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Dropped) != 2 || len(report.Pruned) < 3 || report.Events != 7 || report.Cookies != 4 {
+		t.Error("wrong report", report)
+	}
+	p, err := OpenPartitions(s.PartitionsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, part := range p.Parts {
+		if !part.Start.IsZero() && part.Start.Before(now.AddDate(0, 0, -20)) {
+			t.Error("partition keeps the pruned time range", i, part.Start)
+		}
+	}
+	if c, err := p.Get("old"); err != nil || c != nil {
+		t.Error("expired cookie is still partitioned", err)
+	}
+	if c, err := p.Get("BhrVPRR199e9aC8R"); err != nil || c == nil || len(c.Time()) != 1 {
+		t.Error("wrong pruned partition", err, c)
+	}
+	if got := s.List(""); len(got) != 2 {
+		t.Error("expired shards are still cataloged", got)
+	}
+	for _, shardType := range []string{"StatSet", "CountTimeSet"} {
+		d, err := s.Open(dir+"/foo_2016120600.log", shardType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := d.Get("BhrVPRR199e9aC8R")
+		if d.Size() != 1 || c == nil || len(c.Time()) != 1 || c.Count() != 1 {
+			t.Error(shardType, "wrong pruned shard", d.Size(), c)
+		}
+		if e := s.Entry(dir+"/foo_2016120600.log", shardType); e.Start.Before(now.AddDate(0, 0, -20)) {
+			t.Error(shardType, "catalog keeps the pruned time range", e.Start)
+		}
+	}
+}
+
+func TestGCMerged(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var names []string
+	for _, source := range []string{dir + "/foo_2016110100.log", dir + "/foo_2016110101.log"} {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &Intersection{}, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, false); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s.Path(source, "Intersection"))
+	}
+	outside := dir + "/foo_20161101.log.Intersection.gob"
+	if _, err := CompactShards(outside, names, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Compact(s.Dir+"/foo_20161101.log.Intersection.gob", names, false, true); err != nil {
+		t.Fatal(err)
+	}
+	r := Retention{"*": 20 * 24 * time.Hour}
+	report, err := s.GC(r, time.Date(2016, 11, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(report.Dropped) != 0 {
+		t.Fatal("merged shard dropped before its hours expired", report.Dropped, err)
+	}
+	now := time.Date(2016, 12, 16, 0, 0, 0, 0, time.UTC)
+	if report, err = s.GC(r, now); err != nil || len(report.Dropped) != 1 || len(s.List("")) != 0 {
+		t.Error("merged shard in the store was not dropped", report, err)
+	}
+	if report, err = GCShards([]string{outside}, r, now); err != nil || len(report.Dropped) != 1 {
+		t.Error("merged shard outside the store was not dropped", report, err)
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Error(outside, "was not removed")
+	}
+}
+
+func TestPruneCategories(t *testing.T) {
+	cutoff := time.Unix(1480000000, 0)
+	set := CountTimeCatsSet{
+		"pruned": {CookieID: "pruned", Counter: 2, TStamp: []time.Time{time.Unix(1479585192, 0), time.Unix(1480984187, 0)}, Categories: []CatID{1, 2}},
+		"kept":   {CookieID: "kept", Counter: 1, TStamp: []time.Time{time.Unix(1480984187, 0)}, Categories: []CatID{3}},
+	}
+	if events, cookies, err := pruneShard(&set, cutoff); events != 1 || cookies != 0 || err != nil {
+		t.Fatal("wrong prune", events, cookies, err)
+	}
+	if c := set["pruned"]; len(c.TStamp) != 1 || c.Counter != 1 || len(c.Categories) != 0 {
+		t.Error("cookie keeps the categories of its pruned events", c.Categories)
+	}
+	if c := set["kept"]; len(c.Categories) != 1 {
+		t.Error("cookie without pruned events lost its categories", c.Categories)
+	}
+}
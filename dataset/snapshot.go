@@ -0,0 +1,318 @@
+package cookieDb
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//snapshotManifestName is the last entry of a snapshot archive
+const snapshotManifestName = "SNAPSHOT.json"
+
+//SnapshotFile is a file of a snapshot, Name is its path in the store with / separators
+type SnapshotFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+//SnapshotManifest lists every file of a snapshot archive with its checksum
+type SnapshotManifest struct {
+	Created time.Time      `json:"created"`
+	Shards  int            `json:"shards"`
+	Files   []SnapshotFile `json:"files"`
+}
+
+//snapshotWriter writes files to a tar archive and lists them in a SnapshotManifest
+type snapshotWriter struct {
+	tw       *tar.Writer
+	manifest *SnapshotManifest
+}
+
+//add writes size bytes of r to the archive as name
+func (w *snapshotWriter) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	sum := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(w.tw, sum), r, size); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	w.manifest.Files = append(w.manifest.Files, SnapshotFile{name, size, hex.EncodeToString(sum.Sum(nil))})
+	return nil
+}
+
+//addFile writes the file at path in the store dir to the archive, a missing file is left out.
+//The file is read from one open descriptor, so a shard that is replaced meanwhile is archived whole.
+func (w *snapshotWriter) addFile(dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return w.add(name, info.Size(), info.ModTime(), f)
+}
+
+//Snapshot writes the store to w as a tar archive: the shards of its catalog with the files next to them, its
+//partitions, the catalog itself, the category dictionary, the manifest, the retention and the audit log.
+//The archive ends with a SnapshotManifest of the checksums of every file. The dictionary is archived after the
+//shards, it only grows, so it can decode every archived shard.
+//Snapshot waits for the other processes that have the store open to close it and keeps them from opening it until
+//the archive is written, so it holds one state of the store. It must not run alongside writes to s.
+func (s *Store) Snapshot(w io.Writer) (*SnapshotManifest, error) {
+	if err := flockFile(s.lock, true, true); err != nil {
+		return nil, err
+	}
+	defer flockFile(s.lock, false, true)
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	sw := &snapshotWriter{tw: tar.NewWriter(w), manifest: &SnapshotManifest{Created: time.Now()}}
+	entries := s.List("")
+	for _, e := range entries {
+		shardName := e.File
+		for _, name := range []string{shardName, BloomPath(shardName), MetaPath(shardName), ReportPath(shardName), QuarantinePath(shardName)} {
+			if err := sw.addFile(s.Dir, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sw.manifest.Shards = len(entries)
+	if p, err := OpenPartitions(s.PartitionsDir()); err == nil {
+		base := filepath.Base(p.Dir)
+		for _, part := range p.Parts {
+			for _, name := range []string{part.File, BloomPath(part.File), TimeIndexPath(part.File)} {
+				if err := sw.addFile(s.Dir, path.Join(base, name)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := sw.addFile(s.Dir, path.Join(base, filepath.Base(PartitionsPath(p.Dir)))); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var catalog bytes.Buffer
+	if err := encodeCatalog(&catalog, entries); err != nil {
+		return nil, err
+	}
+	if err := sw.add(filepath.Base(CatalogPath(s.Dir)), int64(catalog.Len()), time.Now(), &catalog); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{DictPath(s.Dir), ManifestPath(s.Dir), RetentionPath(s.Dir), AuditPath(s.Dir)} {
+		if err := sw.addFile(s.Dir, filepath.Base(name)); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := json.MarshalIndent(sw.manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{Name: snapshotManifestName, Mode: 0644, Size: int64(len(manifest)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := sw.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := sw.tw.Write(manifest); err != nil {
+		return nil, err
+	}
+	return sw.manifest, sw.tw.Close()
+}
+
+//Restore unpacks the snapshot archive read from r into the store dir, which must not exist or be empty.
+//The files are unpacked next to dir first. Only when every file matches the checksum in the SnapshotManifest,
+//no file is missing or extra and every shard passes VerifyChecksum, the files are renamed to dir at once.
+func Restore(r io.Reader, dir string) (*SnapshotManifest, error) {
+	if names, err := ioutil.ReadDir(dir); err == nil && len(names) > 0 {
+		return nil, fmt.Errorf("%s is not empty, restore into a new dir", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(dir), filepath.Base(dir)+".restore.*")
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := unpack(r, staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	syncDir(filepath.Dir(dir))
+	return manifest, nil
+}
+
+//MoveSources points the shards of the store at their logs after the logs were moved from the dir from to the dir to,
+//e.g. when a snapshot is restored on a machine that keeps them elsewhere. Shards are named after the full path of
+//their log, see Path, so they are renamed with the files written next to them, and their Meta and manifest entries
+//follow. The store lock is held exclusively while the shards are moved. It returns the number of moved shards.
+func (s *Store) MoveSources(from, to string) (int, error) {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	move := func(path string) string {
+		if path == from || strings.HasPrefix(path, from+string(filepath.Separator)) {
+			return to + path[len(from):]
+		}
+		return path
+	}
+	if err := flockFile(s.lock, true, true); err != nil {
+		return 0, err
+	}
+	defer flockFile(s.lock, false, true)
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	manifest, err := LoadManifest(ManifestPath(s.Dir))
+	if err != nil {
+		return 0, err
+	}
+	renamed := make(map[string]string)
+	moved := 0
+	for _, e := range s.List("") {
+		m := *e
+		m.Source, m.Sources = move(e.Source), nil
+		for _, source := range e.Sources {
+			m.Sources = append(m.Sources, move(source))
+		}
+		if m.Source == e.Source && strings.Join(m.Sources, "\n") == strings.Join(e.Sources, "\n") {
+			continue
+		}
+		if e.Source != "" && e.File == shardFileName(e.Source, e.Type) {
+			m.File = shardFileName(m.Source, e.Type)
+		}
+		oldFiles, files := shardFilesOf(s.File(e)), shardFilesOf(s.File(&m))
+		for i := range files {
+			if err := os.Rename(oldFiles[i], files[i]); err != nil && !os.IsNotExist(err) {
+				return moved, err
+			}
+		}
+		if _, err := os.Stat(MetaPath(s.File(&m))); err == nil {
+			meta, err := ReadMeta(s.File(&m))
+			if err != nil {
+				return moved, err
+			}
+			meta.Source, meta.Sources = m.Source, m.Sources
+			if err := WriteMeta(s.File(&m), meta); err != nil {
+				return moved, err
+			}
+		}
+		s.mu.Lock()
+		delete(s.catalog, e.File)
+		s.changed[e.File] = true
+		s.mu.Unlock()
+		s.set(&m)
+		renamed[e.File] = m.File
+		moved++
+	}
+	syncDir(s.Dir)
+	if err := s.save(); err != nil {
+		return moved, err
+	}
+	return moved, manifest.moveSources(renamed, move)
+}
+
+//unpack writes the files of the archive read from r to dir and verifies them
+func unpack(r io.Reader, dir string) (*SnapshotManifest, error) {
+	tr := tar.NewReader(r)
+	sums := make(map[string]SnapshotFile)
+	var manifest *SnapshotManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			return nil, fmt.Errorf("%s follows the snapshot manifest", hdr.Name)
+		}
+		if hdr.Name == snapshotManifestName {
+			manifest = new(SnapshotManifest)
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("reading snapshot manifest: %v", err)
+			}
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s is not a file of a store", hdr.Name)
+		}
+		if _, ok := sums[name]; ok {
+			return nil, fmt.Errorf("%s is in the archive twice", name)
+		}
+		sum, err := unpackFile(tr, filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		sum.Name = name
+		sums[name] = sum
+	}
+	if manifest == nil {
+		return nil, errors.New("archive has no snapshot manifest, it is not a snapshot or it is truncated")
+	}
+	if len(manifest.Files) != len(sums) {
+		return nil, fmt.Errorf("snapshot manifest lists %d files but the archive holds %d", len(manifest.Files), len(sums))
+	}
+	for _, want := range manifest.Files {
+		if got, ok := sums[want.Name]; !ok || got != want {
+			return nil, fmt.Errorf("%s does not match the snapshot manifest", want.Name)
+		}
+		if strings.HasSuffix(want.Name, ".gob") {
+			if err := VerifyChecksum(filepath.Join(dir, filepath.FromSlash(want.Name))); err != nil && err != ErrNoHeader {
+				return nil, fmt.Errorf("%s: %v", want.Name, err)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+//unpackFile writes r to path and returns its size and checksum
+func unpackFile(r io.Reader, path string) (SnapshotFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return SnapshotFile{}, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, sum), r)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return SnapshotFile{}, err
+	}
+	return SnapshotFile{Size: n, SHA256: hex.EncodeToString(sum.Sum(nil))}, f.Close()
+}
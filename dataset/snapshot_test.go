@@ -0,0 +1,193 @@
+package cookieDb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := dir + "/foo_2016111100.log"
+	if err := os.WriteFile(source, []byte(LINE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(ManifestPath(s.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := CurrentOptions(SkipBadLines)
+	var names []string
+	for i, d := range []Shard{&CountTimeSet{}, &StatSet{}} {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), d, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, i == 1); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, s.Path(source, d.Type()))
+		if err := m.Record(s.Path(source, d.Type()), d.Type(), source, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := PartitionShards(s.PartitionsDir(), 2, names[1:], false); err != nil {
+		t.Fatal(err)
+	}
+
+	//a snapshot waits for the other processes that have the store open and sees what they wrote
+	writer, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	done := make(chan error)
+	var manifest *SnapshotManifest
+	go func() {
+		var err error
+		manifest, err = s.Snapshot(&archive)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("snapshot did not wait for the store to be closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &Intersection{}, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Put(source, d, false); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Shards != 3 {
+		t.Error("wrong number of shards", manifest.Shards)
+	}
+
+	restored := dir + "/restored"
+	if _, err := Restore(bytes.NewReader(archive.Bytes()), restored); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenStore(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.List(""); len(got) != 3 {
+		t.Error("restored catalog is wrong", got)
+	}
+	//the shards are fresh for the logs they were built from, the way main finds them
+	rm, err := LoadManifest(ManifestPath(restored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shardType := range []string{"CountTimeSet", "StatSet"} {
+		if fresh, err := rm.Fresh(r.Path(source, shardType), shardType, source, opts); !fresh || err != nil {
+			t.Error(shardType, "restored shard is not fresh", err)
+		}
+	}
+	restoredShard, err := r.Open(source, "StatSet")
+	if err != nil || restoredShard.Get("BhrVPRR199e9aC8R") == nil {
+		t.Error("restored shard is wrong", err)
+	}
+	p, err := OpenPartitions(r.PartitionsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := p.Get("BhrVPRR199e9aC8R"); err != nil || c == nil {
+		t.Error("restored partitions are wrong", err)
+	}
+	if _, err := Restore(bytes.NewReader(archive.Bytes()), restored); err == nil {
+		t.Error("expected restore into a store that is not empty to fail")
+	}
+
+	//restored where the logs are kept in another dir, the shards are found again once they are moved to it
+	moved := dir + "/logs/foo_2016111100.log"
+	if err := os.MkdirAll(dir+"/logs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(moved, []byte(LINE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(bytes.NewReader(archive.Bytes()), dir+"/elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := OpenStore(dir + "/elsewhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if n, err := e.MoveSources(dir, dir+"/logs"); n != 3 || err != nil {
+		t.Fatal("wrong number of moved shards", n, err)
+	}
+	em, err := LoadManifest(ManifestPath(e.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shardType := range []string{"CountTimeSet", "StatSet"} {
+		if fresh, err := em.Fresh(e.Path(moved, shardType), shardType, moved, opts); !fresh || err != nil {
+			t.Error(shardType, "moved shard is not fresh", err)
+		}
+		if meta, err := ReadMeta(e.Path(moved, shardType)); err != nil || meta.Source != moved {
+			t.Error(shardType, "meta of the moved shard is wrong", meta, err)
+		}
+	}
+	if d, err := e.Open(moved, "Intersection"); err != nil || d.Get("BhrVPRR199e9aC8R") == nil {
+		t.Error("moved shard is not found", err)
+	}
+	if e.Entry(source, "StatSet") != nil {
+		t.Error("shard is still found for the old path of its log")
+	}
+
+	damaged := tamper(t, archive.Bytes(), names[0])
+	if _, err := Restore(bytes.NewReader(damaged), dir+"/damaged"); err == nil {
+		t.Error("expected a damaged archive to fail")
+	}
+	truncated := archive.Bytes()[:archive.Len()/2]
+	if _, err := Restore(bytes.NewReader(truncated), dir+"/truncated"); err == nil {
+		t.Error("expected a truncated archive to fail")
+	}
+	for _, name := range []string{dir + "/damaged", dir + "/truncated"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Error("failed restore made", name, "visible")
+		}
+	}
+	if leftovers, _ := os.ReadDir(dir); len(leftovers) != 5 {
+		t.Error("failed restores left files behind", leftovers)
+	}
+}
+
+//tamper flips a byte in the archived file that shardName is stored as
+func tamper(t *testing.T, archive []byte, shardName string) []byte {
+	var out bytes.Buffer
+	tr, tw := tar.NewReader(bytes.NewReader(archive)), tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if strings.HasSuffix(shardName, "/"+hdr.Name) {
+			data[len(data)/2] ^= 1
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+	return out.Bytes()
+}
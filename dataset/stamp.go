@@ -0,0 +1,176 @@
+package cookieDb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/objconv/bytesconv"
+)
+
+//StampFormat is the way event timestamps are written in the input
+type StampFormat int
+
+const (
+	//AutoStamp tells the formats below apart by their digits, see parseAuto
+	AutoStamp StampFormat = iota
+	UnixSeconds
+	UnixMillis
+	UnixMicros
+	//ISO8601 is RFC 3339 with an optional fraction of a second
+	ISO8601
+)
+
+var stampNames = map[StampFormat]string{
+	AutoStamp:   "auto",
+	UnixSeconds: "s",
+	UnixMillis:  "ms",
+	UnixMicros:  "us",
+	ISO8601:     "iso",
+}
+
+func (f StampFormat) String() string {
+	if name, ok := stampNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("StampFormat(%d)", int(f))
+}
+
+//ParseStampFormat returns the format named by s, one of auto, s, ms, us or iso
+func ParseStampFormat(s string) (StampFormat, error) {
+	for f, name := range stampNames {
+		if strings.EqualFold(s, name) {
+			return f, nil
+		}
+	}
+	return AutoStamp, fmt.Errorf("unknown timestamp format %q", s)
+}
+
+//Stamps is the format the decoders read event timestamps in
+var Stamps = AutoStamp
+
+//unix timestamps below these are taken to be in seconds, milliseconds and microseconds by AutoStamp,
+//which holds for every time between 1973 and 5138
+const (
+	maxAutoSeconds = 1e11
+	maxAutoMillis  = 1e14
+	maxAutoMicros  = 1e17
+)
+
+//parseStamp parses the timestamp of an event in the format set by Stamps, keeping all of its precision
+func parseStamp(raw []byte) (time.Time, error) {
+	t, err := Stamps.parse(raw)
+	if err != nil {
+		return time.Time{}, newParseError(ReasonBadTimestamp, err)
+	}
+	return t, nil
+}
+
+func (f StampFormat) parse(raw []byte) (time.Time, error) {
+	switch f {
+	case UnixSeconds, UnixMillis, UnixMicros:
+		stamp, err := bytesconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return unixIn(f, stamp), nil
+	case ISO8601:
+		return time.Parse(time.RFC3339Nano, string(raw))
+	}
+	return parseAuto(raw)
+}
+
+func unixIn(f StampFormat, stamp int64) time.Time {
+	switch f {
+	case UnixMillis:
+		return time.UnixMilli(stamp)
+	case UnixMicros:
+		return time.UnixMicro(stamp)
+	}
+	return time.Unix(stamp, 0)
+}
+
+//parseAuto reads anything with a '-' after its first digit as ISO-8601, a number with a fraction as seconds
+//and picks the unit of an integer by its size
+func parseAuto(raw []byte) (time.Time, error) {
+	if bytes.IndexByte(raw, '-') > 0 {
+		return time.Parse(time.RFC3339Nano, string(raw))
+	}
+	if i := bytes.IndexByte(raw, '.'); i >= 0 {
+		sec, err := bytesconv.ParseInt(raw[:i], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		frac := string(raw[i+1:])
+		if len(frac) == 0 || len(frac) > 9 {
+			return time.Time{}, fmt.Errorf("bad fraction in timestamp %q", raw)
+		}
+		nsec, err := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		//the fraction of a negative stamp, like -0.5, counts back from its seconds as well
+		if raw[0] == '-' {
+			return time.Unix(sec, -int64(nsec)), nil
+		}
+		return time.Unix(sec, int64(nsec)), nil
+	}
+	stamp, err := bytesconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	abs := stamp
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < maxAutoSeconds:
+		return unixIn(UnixSeconds, stamp), nil
+	case abs < maxAutoMillis:
+		return unixIn(UnixMillis, stamp), nil
+	case abs < maxAutoMicros:
+		return unixIn(UnixMicros, stamp), nil
+	}
+	return time.Unix(0, stamp), nil
+}
+
+//stampEnd returns the length of the timestamp a raw "timestamp:cat,cat" event starts with. ISO-8601 timestamps,
+//told apart like parseAuto does, hold ':' themselves and end after their zone, the others end at the first ':'.
+func stampEnd(raw []byte) int {
+	colon := bytes.IndexByte(raw, ':')
+	if colon < 0 {
+		return len(raw)
+	}
+	if bytes.IndexByte(raw[:colon], '-') <= 0 {
+		return colon
+	}
+	if end := isoEnd(raw); end > 0 {
+		return end
+	}
+	return colon
+}
+
+//isoEnd returns the length of the RFC 3339 timestamp raw starts with, or -1 when it starts with none
+func isoEnd(raw []byte) int {
+	i := bytes.IndexByte(raw, 'T')
+	if i < 0 {
+		return -1
+	}
+	i += 1 + len("15:04:05")
+	if i >= len(raw) {
+		return -1
+	}
+	if raw[i] == '.' {
+		for i++; i < len(raw) && '0' <= raw[i] && raw[i] <= '9'; i++ {
+		}
+	}
+	switch {
+	case i < len(raw) && (raw[i] == 'Z' || raw[i] == 'z'):
+		return i + 1
+	case i < len(raw) && (raw[i] == '+' || raw[i] == '-') && i+len("+07:00") <= len(raw):
+		return i + len("+07:00")
+	}
+	return -1
+}
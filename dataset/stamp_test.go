@@ -0,0 +1,86 @@
+package cookieDb
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStamp(t *testing.T) {
+	sec := time.Unix(1478840400, 0)
+	milli := time.Unix(1478840400, 123000000)
+	micro := time.Unix(1478840400, 123456000)
+	testCases := []struct {
+		format StampFormat
+		raw    string
+		t      time.Time
+	}{
+		{AutoStamp, "1478840400", sec},
+		{AutoStamp, "1478840400123", milli},
+		{AutoStamp, "1478840400123456", micro},
+		{AutoStamp, "1478840400.123456", micro},
+		{AutoStamp, "-1.5", time.Unix(-2, 500000000)},
+		{AutoStamp, "-0.25", time.Unix(0, -250000000)},
+		{AutoStamp, "2016-11-11T05:00:00.123Z", milli},
+		{AutoStamp, "2016-11-11T00:00:00.123456-05:00", micro},
+		{UnixSeconds, "1478840400", sec},
+		{UnixMillis, "1478840400123", milli},
+		{UnixMicros, "1478840400123456", micro},
+		{ISO8601, "2016-11-11T05:00:00Z", sec},
+	}
+	for _, test := range testCases {
+		got, err := test.format.parse([]byte(test.raw))
+		if err != nil || !got.Equal(test.t) {
+			t.Error(test.format, test.raw, got, err)
+		}
+	}
+	for _, raw := range []string{"soon", "1478840400.", "2016-11-11", ""} {
+		if _, err := parseAuto([]byte(raw)); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+	for raw, reason := range map[string]Reason{
+		"2016-11-11T05:00:00Z":        ReasonMissingCategories,
+		"2016-11-11T05:00:00.5+01:00": ReasonMissingCategories,
+		"1478840400":                  ReasonMissingCategories,
+		"2016-11-11T05:00:00Zx:3":     ReasonBadTimestamp,
+		"2016-11-11:3":                ReasonBadTimestamp,
+	} {
+		_, _, err := parseEvent([]byte(raw))
+		if perr, ok := err.(*ParseError); !ok || perr.Reason != reason {
+			t.Errorf("%q: expected %s, got %v", raw, reason, err)
+		}
+	}
+	if ts, cats, err := parseEvent([]byte("2016-11-11T00:00:00.123-05:00:3,17")); err != nil || !ts.Equal(milli) || string(cats) != "3,17" {
+		t.Error("ISO event with an offset is split wrong", ts, string(cats), err)
+	}
+	if _, err := UnixMillis.parse([]byte("2016-11-11T05:00:00Z")); err == nil {
+		t.Error("expected an error for an ISO timestamp when milliseconds are configured")
+	}
+}
+
+func TestSubSecondEvents(t *testing.T) {
+	lines := "cookieA\t2016-11-11T05:00:00.25Z:3,17;1478840400500:4\ncookieA\t1478840400.75:5"
+	d := make(CountTimeSet)
+	if _, _, err := FillDb(bufio.NewScanner(strings.NewReader(lines)), &d, "test_2016111100.log", &FillOptions{Policy: AbortOnBadLine}); err != nil {
+		t.Fatal(err)
+	}
+	stamps := d.Get("cookieA").Time()
+	if len(stamps) != 3 || stamps[0].Nanosecond() != 250000000 || stamps[1].Nanosecond() != 500000000 || stamps[2].Nanosecond() != 750000000 {
+		t.Error("timestamps lost their precision", stamps)
+	}
+
+	fileTime, err := ParseTime("test_2016111100.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Event{T: fileTime.Add(time.Hour - time.Millisecond)}
+	if e.Hist(&fileTime) {
+		t.Error("event in the last millisecond of the hour is history")
+	}
+	e.T = fileTime.Add(-time.Millisecond)
+	if !e.Hist(&fileTime) {
+		t.Error("event a millisecond before the hour is not history")
+	}
+}
@@ -0,0 +1,507 @@
+package cookieDb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrNotInStore is returned for shards the catalog of a Store does not hold
+var ErrNotInStore = errors.New("shard is not in the store")
+
+//ErrStoreBusy is returned when the lock of a store is held by another process
+var ErrStoreBusy = errors.New("store is in use by another process")
+
+//errLocked is returned by flockFile for a lock it did not wait for
+var errLocked = errors.New("file is locked")
+
+//CatalogEntry describes a shard of a Store
+type CatalogEntry struct {
+	//File is the name of the shard file in the dir of the store
+	File   string `json:"file"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	//Sources are the logs of the shards that were compacted into this one by Compact, Source is empty then
+	Sources []string `json:"sources,omitempty"`
+	//Start and End are the earliest and latest event in the shard, they are zero for shards without times
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Bytes int64     `json:"bytes"`
+	Count int       `json:"count"`
+}
+
+//Store is a directory of shards with a catalog of them, so shards can be listed and kept apart from their logs.
+//The shard of a log is named after the log and its type, see Path. It is safe for concurrent use.
+//An open store holds a shared lock on its dir until Close, so Recover does not run while it is written.
+type Store struct {
+	Dir     string
+	mu      sync.Mutex
+	catalog map[string]*CatalogEntry
+	//changed are the files whose entries were set or dropped since the catalog was last saved
+	changed map[string]bool
+	lock    *os.File
+}
+
+//storeSuffixes end the names of the files a store writes next to its shards
+var storeSuffixes = []string{".gob", ".rejected", ".report.json", ".meta.json", ".bloom", ".dict", ".times", ".tmp", "manifest.json", "catalog.json", "retention.json", "erasure.log", ".lock"}
+
+//CatalogPath returns the file in the store dir that holds its catalog
+func CatalogPath(dir string) string {
+	return filepath.Join(dir, "catalog.json")
+}
+
+//LockPath returns the file in the store dir that is locked by the processes that have it open
+func LockPath(dir string) string {
+	return filepath.Join(dir, "store.lock")
+}
+
+//lockStore locks the store in dir until the returned file is closed, the processes that write to the store share
+//the lock and an exclusive one keeps them out. Unless wait is set it returns ErrStoreBusy when it is held.
+func lockStore(dir string, exclusive, wait bool) (*os.File, error) {
+	f, err := os.OpenFile(LockPath(dir), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flockFile(f, exclusive, wait); err != nil {
+		f.Close()
+		if err == errLocked {
+			return nil, ErrStoreBusy
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+//withLock runs fn while holding an exclusive lock on path+".lock", so that processes that read path, change it
+//and write it back do so one after the other
+func withLock(path string, fn func() error) error {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	return fn()
+}
+
+//OpenStore opens the store in dir, it is created when dir does not exist. A dir without a catalog, like one
+//written before stores had one, is cataloged from the Meta of its shards. Entries whose shard file is gone,
+//e.g. removed by Recover, are dropped.
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockStore(dir, false, true)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{Dir: dir, catalog: make(map[string]*CatalogEntry), changed: make(map[string]bool), lock: lock}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//load reads the catalog of the store from its file
+func (s *Store) load() error {
+	if _, err := os.Stat(CatalogPath(s.Dir)); os.IsNotExist(err) {
+		return s.scan()
+	}
+	catalog, err := s.read()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.catalog, s.changed = catalog, make(map[string]bool)
+	s.mu.Unlock()
+	return nil
+}
+
+//read returns the entries saved in the catalog file whose shard file exists, a missing file has none
+func (s *Store) read() (map[string]*CatalogEntry, error) {
+	f, err := os.Open(CatalogPath(s.Dir))
+	if os.IsNotExist(err) {
+		return make(map[string]*CatalogEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*CatalogEntry
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	catalog := make(map[string]*CatalogEntry, len(entries))
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(s.Dir, e.File)); err == nil {
+			catalog[e.File] = e
+		}
+	}
+	return catalog, nil
+}
+
+//set catalogs e without saving the catalog
+func (s *Store) set(e *CatalogEntry) {
+	s.mu.Lock()
+	s.catalog[e.File] = e
+	s.changed[e.File] = true
+	s.mu.Unlock()
+}
+
+//Close releases the lock of the store
+func (s *Store) Close() error {
+	return s.lock.Close()
+}
+
+//scan catalogs the shards in the dir of the store whose Meta names their source
+func (s *Store) scan() error {
+	names, err := filepath.Glob(filepath.Join(s.Dir, "*.gob"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		meta, err := ReadMeta(name)
+		if err != nil || meta.Source == "" && len(meta.Sources) == 0 {
+			continue
+		}
+		e, err := catalogEntry(meta.Source, name)
+		if err != nil {
+			continue
+		}
+		e.Sources = meta.Sources
+		s.set(e)
+	}
+	return s.save()
+}
+
+//catalogEntry describes the shard file shardName of source, from its header
+func catalogEntry(source, shardName string) (*CatalogEntry, error) {
+	info, err := os.Stat(shardName)
+	if err != nil {
+		return nil, err
+	}
+	h, err := ReadHeader(shardName)
+	if err != nil {
+		return nil, err
+	}
+	return &CatalogEntry{
+		File:   filepath.Base(shardName),
+		Type:   h.Type,
+		Source: source,
+		Start:  h.Start,
+		End:    h.End,
+		Bytes:  info.Size(),
+		Count:  h.Count,
+	}, nil
+}
+
+//sources returns the logs the shard of e holds the lines of
+func (e *CatalogEntry) sources() []string {
+	if len(e.Sources) > 0 {
+		return e.Sources
+	}
+	return []string{e.Source}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//PartitionsDir returns the dir the partitions of the store are kept in
+func (s *Store) PartitionsDir() string {
+	return filepath.Join(s.Dir, "partitions")
+}
+
+//Path returns the shard file of the shardType shard of source, whether it exists or not. It is named after the
+//base name of source and a hash of its full path, so logs of one name in different dirs get a shard each.
+//Shards cataloged under the name stores gave them before, without the hash, keep it.
+func (s *Store) Path(source, shardType string) string {
+	if e := s.unhashedEntry(source, shardType); e != nil {
+		return s.File(e)
+	}
+	return filepath.Join(s.Dir, shardFileName(source, shardType))
+}
+
+//shardFileName returns the name of the shardType shard of source in the dir of a store
+func shardFileName(source, shardType string) string {
+	path, err := filepath.Abs(source)
+	if err != nil {
+		path = source
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Base(source) + "." + hex.EncodeToString(sum[:4]) + "." + shardType + ".gob"
+}
+
+//unhashedEntry returns the catalog entry of the shardType shard of source when it is named without the hash
+//of shardFileName, or nil
+func (s *Store) unhashedEntry(source, shardType string) *CatalogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.catalog[filepath.Base(source)+"."+shardType+".gob"]
+	if e == nil || e.Source != source || e.Type != shardType {
+		return nil
+	}
+	return e
+}
+
+//File returns the shard file of e
+func (s *Store) File(e *CatalogEntry) string {
+	return filepath.Join(s.Dir, e.File)
+}
+
+//Entry returns the catalog entry of the shardType shard of source, or nil
+func (s *Store) Entry(source, shardType string) *CatalogEntry {
+	s.mu.Lock()
+	e := s.catalog[shardFileName(source, shardType)]
+	s.mu.Unlock()
+	if e == nil || e.Source != source {
+		return s.unhashedEntry(source, shardType)
+	}
+	return e
+}
+
+//Merged returns the catalog entry of the shard the shardType shard of source was compacted into, or nil.
+//When it was compacted more than once the shard merged from the most logs is returned.
+func (s *Store) Merged(source, shardType string) *CatalogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var merged *CatalogEntry
+	for _, e := range s.catalog {
+		if e.Type != shardType || !contains(e.Sources, source) {
+			continue
+		}
+		if merged == nil || len(e.Sources) > len(merged.Sources) || len(e.Sources) == len(merged.Sources) && e.File < merged.File {
+			merged = e
+		}
+	}
+	return merged
+}
+
+//List returns the catalog entries of the shards of shardType, or of every shard when it is empty,
+//in the order of their time range
+func (s *Store) List(shardType string) []*CatalogEntry {
+	s.mu.Lock()
+	var entries []*CatalogEntry
+	for _, e := range s.catalog {
+		if shardType == "" || e.Type == shardType {
+			entries = append(entries, e)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Start.Equal(entries[j].Start) {
+			return entries[i].Start.Before(entries[j].Start)
+		}
+		return entries[i].File < entries[j].File
+	})
+	return entries
+}
+
+//Open reads the shardType shard of source with ReadShard
+func (s *Store) Open(source, shardType string) (Shard, error) {
+	if s.Entry(source, shardType) == nil {
+		return nil, ErrNotInStore
+	}
+	return ReadShard(s.Path(source, shardType))
+}
+
+//Put writes d as the shard of source, in the indexed format when indexed is set, with its Meta, and catalogs it
+func (s *Store) Put(source string, d Shard, indexed bool) (*CatalogEntry, error) {
+	shardName := s.Path(source, d.Type())
+	write := WriteShard
+	if indexed {
+		write = WriteIndexedShard
+	}
+	if err := write(shardName, d); err != nil {
+		return nil, err
+	}
+	meta := &Meta{Type: d.Type(), Source: source, Timezone: FileTimes.Loc().String()}
+	if err := WriteMeta(shardName, meta); err != nil {
+		return nil, err
+	}
+	return s.Record(source, d.Type())
+}
+
+//Record catalogs the shardType shard of source that was written to Path without Put, e.g. by Follow or AppendShard
+func (s *Store) Record(source, shardType string) (*CatalogEntry, error) {
+	e, err := catalogEntry(source, s.Path(source, shardType))
+	if err != nil {
+		return nil, err
+	}
+	s.set(e)
+	return e, s.save()
+}
+
+//update catalogs the shard of e again after it was rewritten in place, e.g. by GC or Erase
+func (s *Store) update(e *CatalogEntry) (*CatalogEntry, error) {
+	updated, err := catalogEntry(e.Source, s.File(e))
+	if err != nil {
+		return nil, err
+	}
+	updated.Sources = e.Sources
+	s.set(updated)
+	return updated, s.save()
+}
+
+//Compact merges the shards names of the store into the shard file out in its dir with CompactShards, and catalogs
+//it with the sources of all of them so Merged finds it in their place. With remove the merged shards are deleted
+//with the files written next to them and dropped from the catalog.
+func (s *Store) Compact(out string, names []string, indexed, remove bool) (*CatalogEntry, error) {
+	if filepath.Dir(out) != filepath.Clean(s.Dir) {
+		return nil, fmt.Errorf("%s is not in the store %s", out, s.Dir)
+	}
+	var merged []*CatalogEntry
+	var sources []string
+	s.mu.Lock()
+	for _, name := range names {
+		e := s.catalog[filepath.Base(name)]
+		if e == nil || filepath.Dir(name) != filepath.Clean(s.Dir) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%s: %v", name, ErrNotInStore)
+		}
+		if remove && e.File == filepath.Base(out) {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%s is one of the shards that are removed", out)
+		}
+		merged = append(merged, e)
+		sources = append(sources, e.sources()...)
+	}
+	s.mu.Unlock()
+	h, err := CompactShards(out, names, indexed)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteMeta(out, &Meta{Type: h.Type, Sources: sources, Timezone: h.Timezone}); err != nil {
+		return nil, err
+	}
+	e, err := catalogEntry("", out)
+	if err != nil {
+		return nil, err
+	}
+	e.Sources = sources
+	s.set(e)
+	if remove {
+		for _, old := range merged {
+			if err := s.remove(old); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, s.save()
+}
+
+//Delete removes the shardType shard of source with the files written next to it, and drops it from the catalog
+func (s *Store) Delete(source, shardType string) error {
+	if err := s.remove(&CatalogEntry{File: filepath.Base(s.Path(source, shardType))}); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+//remove removes the shard of e with RemoveShard and drops it from the catalog, without saving it
+func (s *Store) remove(e *CatalogEntry) error {
+	if err := RemoveShard(s.File(e)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.catalog, e.File)
+	s.changed[e.File] = true
+	s.mu.Unlock()
+	return nil
+}
+
+//RemoveShard removes shardName with the files written next to it
+func RemoveShard(shardName string) error {
+	for _, path := range shardFilesOf(shardName) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//shardFilesOf returns shardName and the files written next to it
+func shardFilesOf(shardName string) []string {
+	return []string{shardName, BloomPath(shardName), MetaPath(shardName), ReportPath(shardName), QuarantinePath(shardName)}
+}
+
+//Has tells if the shard file path is in the catalog of the store
+func (s *Store) Has(path string) bool {
+	s.mu.Lock()
+	_, ok := s.catalog[filepath.Base(path)]
+	s.mu.Unlock()
+	return ok && filepath.Dir(path) == filepath.Clean(s.Dir)
+}
+
+//Owns tells if the file path was written by the store, rather than being a log
+func (s *Store) Owns(path string) bool {
+	if s.Has(path) {
+		return true
+	}
+	for _, suffix := range storeSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+//encodeCatalog writes entries the way they are saved in the catalog file
+func encodeCatalog(w io.Writer, entries []*CatalogEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].File < entries[j].File })
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(entries)
+}
+
+//save writes the entries that were changed since the catalog was last saved to the catalog file. Other processes
+//writing to the store save it too, so the file is locked and read again first and the entries they changed are kept.
+func (s *Store) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := CatalogPath(s.Dir)
+	return withLock(path, func() error {
+		catalog, err := s.read()
+		if err != nil {
+			return err
+		}
+		for file := range s.changed {
+			if e, ok := s.catalog[file]; ok {
+				catalog[file] = e
+			} else {
+				delete(catalog, file)
+			}
+		}
+		entries := make([]*CatalogEntry, 0, len(catalog))
+		for _, e := range catalog {
+			entries = append(entries, e)
+		}
+		var buf bytes.Buffer
+		if err := encodeCatalog(&buf, entries); err != nil {
+			return err
+		}
+		if err := replaceFile(path, buf.Bytes()); err != nil {
+			return err
+		}
+		s.catalog, s.changed = catalog, make(map[string]bool)
+		return nil
+	})
+}
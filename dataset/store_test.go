@@ -0,0 +1,229 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []string{dir + "/logs/foo_2016111101.log", dir + "/logs/foo_2016111100.log"}
+	for _, source := range sources {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &CountTimeSet{}, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := s.Put(source, d, source == sources[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != "CountTimeSet" || e.Count != 1 || e.Bytes == 0 || e.Start.IsZero() || e.Source != source {
+			t.Error("wrong catalog entry", e)
+		}
+	}
+	if _, err := s.Put(sources[0], &StatSet{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.List("CountTimeSet"); len(got) != 2 || got[0].Source != sources[1] {
+		t.Error("wrong list", got)
+	}
+	if got := s.List(""); len(got) != 3 {
+		t.Error("wrong list of every type", got)
+	}
+	d, err := s.Open(sources[0], "CountTimeSet")
+	if err != nil || d.Get("BhrVPRR199e9aC8R") == nil {
+		t.Error("could not open shard", err)
+	}
+	if c, ok := d.(readOnlyShard); ok {
+		c.Close()
+	}
+	if _, err := s.Open(dir+"/logs/missing.log", "CountTimeSet"); err != ErrNotInStore {
+		t.Error("expected ErrNotInStore, got", err)
+	}
+	if !s.Owns(s.Path(sources[0], "CountTimeSet")) || !s.Owns(CatalogPath(s.Dir)) || s.Owns(sources[0]) {
+		t.Error("wrong owner of files")
+	}
+
+	if err := s.Delete(sources[0], "StatSet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(MetaPath(s.Path(sources[0], "StatSet"))); !os.IsNotExist(err) {
+		t.Error("deleted shard left its meta behind")
+	}
+	os.Remove(s.Path(sources[1], "CountTimeSet"))
+	s, err = OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.List(""); len(got) != 1 || got[0].Source != sources[0] {
+		t.Error("reopened store has the wrong catalog", got)
+	}
+
+	os.Remove(CatalogPath(s.Dir))
+	s, err = OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Entry(sources[0], "CountTimeSet"); e == nil || e.Count != 1 {
+		t.Error("store without a catalog was not scanned", e)
+	}
+}
+
+func TestStorePath(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []string{dir + "/eu/clicks_2016111100.log", dir + "/us/clicks_2016111100.log"}
+	if s.Path(sources[0], "CountTimeSet") == s.Path(sources[1], "CountTimeSet") {
+		t.Fatal("logs of one name in different dirs share a shard")
+	}
+	for i, source := range sources {
+		input := strings.Repeat(LINE+"\n", i) + "newCookie\t1478840501:4"
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(input)), &CountTimeSet{}, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, source := range sources {
+		if e := s.Entry(source, "CountTimeSet"); e == nil || e.Count != i+1 {
+			t.Error(source, "has the wrong shard", e)
+		}
+	}
+
+	unhashed := s.Dir + "/clicks_2016111101.log.CountTimeSet.gob"
+	source := dir + "/eu/clicks_2016111101.log"
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &CountTimeSet{}, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(unhashed, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMeta(unhashed, &Meta{Type: "CountTimeSet", Source: source, Timezone: LOC.String()}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	os.Remove(CatalogPath(s.Dir))
+	if s, err = OpenStore(dir + "/store"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Path(source, "CountTimeSet") != unhashed || s.Entry(source, "CountTimeSet") == nil {
+		t.Error("shard named before paths were hashed is not found", s.Path(source, "CountTimeSet"))
+	}
+	if s.Path(dir+"/us/clicks_2016111101.log", "CountTimeSet") == unhashed {
+		t.Error("shard named before paths were hashed is taken by another log")
+	}
+}
+
+func TestStoreConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	var stores []*Store
+	for i := 0; i < 2; i++ {
+		//every writer opens the store before the others cataloged anything
+		s, err := OpenStore(dir + "/store")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stores = append(stores, s)
+	}
+	sources := []string{dir + "/foo_2016111100.log", dir + "/foo_2016111101.log"}
+	for i, s := range stores {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &CountTimeSet{}, sources[i], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(sources[i], d, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stores[0].Delete(sources[0], "CountTimeSet"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.List(""); len(got) != 1 || got[0].Source != sources[1] {
+		t.Error("entries of another writer are lost", got)
+	}
+	if matches, _ := filepath.Glob(s.Dir + "/*.tmp"); len(matches) != 0 {
+		t.Error("temporary files are left", matches)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := []string{dir + "/logs/foo_2016111100.log", dir + "/logs/foo_2016111101.log"}
+	var names []string
+	for _, source := range sources {
+		d, _, err := FillDb(bufio.NewScanner(strings.NewReader(LINE)), &CountTimeSet{}, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Put(source, d, false); err != nil {
+			t.Fatal(err)
+		}
+		shardName := s.Path(source, "CountTimeSet")
+		if err := os.WriteFile(ReportPath(shardName), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, shardName)
+	}
+	out := s.Dir + "/foo_20161111.log.CountTimeSet.gob"
+	if _, err := s.Compact(dir+"/elsewhere.gob", names, false, true); err == nil {
+		t.Error("expected an error for a merged shard outside the store")
+	}
+	e, err := s.Compact(out, names, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Count != 1 || len(e.Sources) != 2 || e.Source != "" {
+		t.Error("wrong catalog entry of the merged shard", e)
+	}
+	for _, source := range sources {
+		if m := s.Merged(source, "CountTimeSet"); m == nil || m.File != e.File {
+			t.Error(source, "is not merged", m)
+		}
+		if s.Entry(source, "CountTimeSet") != nil {
+			t.Error(source, "is still cataloged")
+		}
+	}
+	if s.Merged(sources[0], "StatSet") != nil {
+		t.Error("merged shard found for another type")
+	}
+	for _, name := range names {
+		for _, path := range []string{name, MetaPath(name), ReportPath(name)} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error(path, "was not removed")
+			}
+		}
+	}
+
+	os.Remove(CatalogPath(s.Dir))
+	s, err = OpenStore(dir + "/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Merged(sources[1], "CountTimeSet"); m == nil || s.File(m) != out {
+		t.Error("merged shard was not scanned", m)
+	}
+}
@@ -0,0 +1,148 @@
+package cookieDb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//The checks of VerifyShard, they count the problems of a Verification
+const (
+	CheckZeroTime      = "zero_time"
+	CheckOutsideWindow = "time_outside_window"
+	CheckEmptyID       = "empty_cookie_id"
+	CheckCount         = "count_mismatch"
+	CheckEmptyCategory = "empty_category"
+)
+
+//maxExamples is the number of problems a Verification lists, the rest are only counted
+const maxExamples = 10
+
+//VerifyOptions configure VerifyShard
+type VerifyOptions struct {
+	//History is how long before the hour of its file an event may be, logs carry the history of their cookies
+	//in their lines. Any event before the hour is taken as history when it is 0.
+	History time.Duration
+}
+
+//Problem is a cookie that failed a check
+type Problem struct {
+	Cookie string `json:"cookie"`
+	Check  string `json:"check"`
+	Detail string `json:"detail"`
+}
+
+//Verification is the outcome of VerifyShard for one shard file
+type Verification struct {
+	Shard   string `json:"shard"`
+	Type    string `json:"type,omitempty"`
+	Cookies int    `json:"cookies"`
+	//Checksum is ok, none for files written before checksums or failed
+	Checksum string `json:"checksum"`
+	//Error is set when the shard could not be checked at all
+	Error    string         `json:"error,omitempty"`
+	Problems map[string]int `json:"problems,omitempty"`
+	Examples []Problem      `json:"examples,omitempty"`
+	//History counts the timestamps before the hour of the file, from the history of their cookies
+	History int `json:"history"`
+}
+
+//OK tells if the shard passed every check
+func (v *Verification) OK() bool {
+	return v.Error == "" && len(v.Problems) == 0
+}
+
+func (v *Verification) problem(cookie, check, detail string) {
+	if v.Problems == nil {
+		v.Problems = make(map[string]int)
+	}
+	v.Problems[check]++
+	if len(v.Examples) < maxExamples {
+		v.Examples = append(v.Examples, Problem{cookie, check, detail})
+	}
+}
+
+//VerifyShard checks the checksum of the shard file shardName, decodes it with ReadShard and checks its cookies.
+//Every cookie needs an id, its timestamps can not be after the hour of the file as FileTimes reads it from the
+//name, the ones before it are counted as history, see VerifyOptions, its count can not be more than its timestamps and none of its categories may be empty.
+//Category ids are looked up in Categories, which has to be the Dict of the store of the shard. Shards whose name
+//holds no hour, like compacted shards, only get their timestamps checked against 1970.
+func VerifyShard(shardName string, opts VerifyOptions) *Verification {
+	v := &Verification{Shard: shardName, Checksum: "ok"}
+	err := VerifyChecksum(shardName)
+	if errors.Is(err, ErrChecksum) {
+		v.Checksum = "failed"
+		v.Error = err.Error()
+		return v
+	}
+	if err != nil && err != ErrNoHeader {
+		v.Error = err.Error()
+		return v
+	}
+	d, h, err := readShard(shardName)
+	if err != nil {
+		v.Checksum = "none"
+		v.Error = err.Error()
+		return v
+	}
+	if h.footerSize() == 0 {
+		v.Checksum = "none"
+	}
+	if s, ok := d.(readOnlyShard); ok {
+		defer s.Close()
+	}
+	v.Type, v.Cookies = d.Type(), d.Size()
+	from, hour, to := time.Unix(1, 0), time.Time{}, time.Time{}
+	if loc, err := time.LoadLocation(h.Timezone); err == nil {
+		x := &FileTimeExtractor{Pattern: FileTimes.Pattern, Layout: FileTimes.Layout, Location: loc}
+		if t, err := x.extract(shardName); err == nil {
+			hour, to = t, t.Add(time.Hour)
+			if opts.History > 0 {
+				from = hour.Add(-opts.History)
+			}
+		}
+	}
+	for _, c := range d.GetElems(d.Size()) {
+		verifyCookie(v, c, from, hour, to)
+	}
+	return v
+}
+
+//verifyCookie checks c, its timestamps have to be in [from, to), to is left out when it is zero.
+//The ones before hour are history.
+func verifyCookie(v *Verification, c Cookie, from, hour, to time.Time) {
+	id := c.ID()
+	if id == "" {
+		v.problem(id, CheckEmptyID, "cookie has no id")
+	}
+	times := c.Time()
+	for _, t := range times {
+		if t.Unix() <= 0 {
+			v.problem(id, CheckZeroTime, fmt.Sprintf("timestamp %s", t))
+		} else if t.Before(from) || !to.IsZero() && !t.Before(to) {
+			v.problem(id, CheckOutsideWindow, fmt.Sprintf("timestamp %s is not in [%s, %s)", t, from, to))
+		} else if t.Before(hour) {
+			v.History++
+		}
+	}
+	//every line that was counted, a session of a StatSet, brought at least one timestamp
+	if v.Type != "Intersection" && (c.Count() < 1 || c.Count() > len(times)) {
+		v.problem(id, CheckCount, fmt.Sprintf("count %d but %d timestamps", c.Count(), len(times)))
+	}
+	if u := c.User(); u != nil {
+		for _, s := range u.Sess {
+			if len(s.Events) == 0 {
+				v.problem(id, CheckCount, "session without events")
+			}
+		}
+	}
+	if v.Type != "CountTimeCatsSet" && v.Type != "StatSet" {
+		//the other types have no categories, their Cats is a single empty string
+		return
+	}
+	for _, cat := range c.Cats() {
+		if cat == "" {
+			v.problem(id, CheckEmptyCategory, "category is empty or not in the dictionary")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cookieDb
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyShard(t *testing.T) {
+	dir := t.TempDir()
+	opts := VerifyOptions{History: 30 * 24 * time.Hour}
+	hour := time.Unix(1480984187, 0).In(FileTimes.Loc()).Format(DefaultTimeLayout)
+	good := dir + "/foo_" + hour + ".log.StatSet.gob"
+	d, _, err := FillDb(bufio.NewScanner(strings.NewReader("BhrVPRR199e9aC8R\t1480984187:4;1480984190:5")), &StatSet{}, good, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(good, d); err != nil {
+		t.Fatal(err)
+	}
+	if v := VerifyShard(good, VerifyOptions{}); !v.OK() || v.Checksum != "ok" || v.Cookies != 1 {
+		t.Error("good shard failed verification", v)
+	}
+	history := dir + "/foo_2016120600.log.StatSet.gob"
+	if d, _, err = FillDb(bufio.NewScanner(strings.NewReader(LINE)), &StatSet{}, history, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(history, d); err != nil {
+		t.Fatal(err)
+	}
+	if v := VerifyShard(history, VerifyOptions{}); !v.OK() || v.History != 2 {
+		t.Error("events in the history of the cookie failed verification", v)
+	}
+	if v := VerifyShard(history, VerifyOptions{History: 24 * time.Hour}); v.Problems[CheckOutsideWindow] != 1 || v.History != 1 {
+		t.Error("events before the history were not found", v.Problems)
+	}
+	early := dir + "/foo_2016110100.log.StatSet.gob"
+	if err := WriteShard(early, d); err != nil {
+		t.Fatal(err)
+	}
+	if v := VerifyShard(early, opts); v.Problems[CheckOutsideWindow] != 2 {
+		t.Error("events after the hour of the file were not found", v.Problems)
+	}
+	//the fixtures carry the history of their cookies
+	fixtures := dir + "/test_2016120606.log.StatSet.gob"
+	f, err := os.Open("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if d, _, err = FillDb(bufio.NewScanner(f), &StatSet{}, fixtures, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShard(fixtures, d); err != nil {
+		t.Fatal(err)
+	}
+	if v := VerifyShard(fixtures, VerifyOptions{}); !v.OK() || v.History == 0 {
+		t.Error("shard of the fixtures failed verification", v.Problems, v.Examples)
+	}
+
+	sessions := dir + "/foo_2016120600.log.sessions.gob"
+	users := StatSet{
+		"empty": {CookieID: "empty", Sess: []Session{{Events: []Event{{T: time.Unix(1480984187, 0)}}}, {}}},
+		"none":  {CookieID: "none"},
+	}
+	if err := WriteShard(sessions, &users); err != nil {
+		t.Fatal(err)
+	}
+	if v := VerifyShard(sessions, opts); v.Problems[CheckCount] != 3 {
+		t.Error("wrong count of", CheckCount, v.Problems, v.Examples)
+	}
+
+	bad := dir + "/foo_2016120600.log.CountTimeCatsSet.gob"
+	set := CountTimeCatsSet{
+		"":     {Counter: 1, TStamp: []time.Time{time.Unix(1480984187, 0)}, Categories: Categories.IDs([]string{"1"})},
+		"zero": {CookieID: "zero", Counter: 1, TStamp: []time.Time{time.Unix(0, 0)}, Categories: Categories.IDs([]string{"1"})},
+		"many": {CookieID: "many", Counter: 3, TStamp: []time.Time{time.Unix(1480984187, 0)}, Categories: []CatID{CatID(Categories.Len() + 7)}},
+	}
+	if err := WriteShard(bad, &set); err != nil {
+		t.Fatal(err)
+	}
+	v := VerifyShard(bad, opts)
+	for _, check := range []string{CheckEmptyID, CheckZeroTime, CheckCount, CheckEmptyCategory} {
+		if v.Problems[check] != 1 {
+			t.Error("wrong count of", check, v.Problems)
+		}
+	}
+	if len(v.Examples) != 4 || v.OK() {
+		t.Error("wrong examples", v.Examples)
+	}
+
+	data, _ := os.ReadFile(good)
+	os.WriteFile(good, data[:len(data)-1], 0644)
+	if v := VerifyShard(good, opts); v.Checksum != "failed" || v.OK() {
+		t.Error("truncated shard passed verification", v)
+	}
+}
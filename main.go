@@ -8,6 +8,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"time"
 )
 
@@ -18,14 +21,102 @@ var times = flag.Bool("time", true, "show the timestamps for the user")
 var all = flag.Bool("all", true, "show everything")
 var firstDir = flag.String("intersection", "", "dir that holds the files to witch cookie ids to check the dataset for")
 var thirdDir = flag.String("dataset", "", "dir that holds the files from which the data set should be created")
+var storeFlag = flag.String("store", "", "dir the shards are kept in, by default the dataset dir or the dir of the first file")
+var badLines = flag.String("badLines", "skip", "what to do with lines that fail to parse: skip, abort or quarantine (written next to the shard)")
+var workers = flag.Int("workers", runtime.NumCPU(), "number of files that are turned into shards in parallel")
+var follow = flag.String("follow", "", "log file of the current hour to keep reading as it is written, its shard is checkpointed until the hour is over")
+var checkpoint = flag.Duration("checkpoint", time.Minute, "how often the followed shard is written")
+var timePattern = flag.String("timePattern", cookieDb.DefaultTimePattern, "regexp that finds the hour in a log file name, its first group is used when it has one")
+var timeLayout = flag.String("timeLayout", cookieDb.DefaultTimeLayout, "layout of the hour matched by timePattern")
+var timeFallback = flag.String("timeFallback", "none", "where the hour of a file whose name holds none comes from: none, mtime or events")
+var timezone = flag.String("tz", cookieDb.LOC.String(), "timezone of the dataset, used for the hours in the file names and for printing events")
+var stamps = flag.String("stamps", "auto", "format of the event timestamps: auto, s, ms, us or iso")
+var appendTo = flag.String("appendTo", "", "log file whose shard the late lines in the files given as arguments are appended to")
+var indexed = flag.Bool("indexed", false, "write new shards in the indexed format, so single cookies are read without decoding the whole shard")
+var mmap = flag.Bool("mmap", false, "map indexed shards into memory, so processes on the same host share their pages")
+var bloomFP = flag.Float64("bloomFP", cookieDb.BloomFPRate, "false positive rate of the bloom filters written next to the shards, 0 writes none")
+var partitions = flag.Int("partitions", 0, "re-partition the shards into this many partitions by a hash of the cookie id, so the history of a cookie is read from one partition")
+var cacheBytes = flag.Int64("cacheBytes", 1<<30, "estimated memory the decoded shards are cached in while the sample is looked up")
+var recoverStore = flag.Bool("recover", true, "remove the temporary files and damaged shards a crash left in the store before building, so the shards are rebuilt. It is skipped while another process has the store open")
 var timeFrame = flag.Int("timeFrame", 2, "Number of hours before the date in the name of the file that a cookie will be considered new data and not history")
 
 type dataset struct {
-	shards        []string
-	sample        []cookieDb.Cookie
-	sampleSize    int
-	loadedShard   cookieDb.Shard
-	loadedShardID string
+	shards     []string
+	sample     []cookieDb.Cookie
+	sampleSize int
+	cache      *cookieDb.ShardCache
+	blooms     map[string]*cookieDb.Bloom
+	parts      *cookieDb.Partitions
+}
+
+//lookup calls f with the entry of the i-th sampled cookie in every shard that holds it.
+//A partitioned dataset reads only the partitions of the sampled cookies.
+func (s *dataset) lookup(f func(i int, c cookieDb.Cookie)) {
+	if s.parts != nil {
+		sampled := make([][]int, len(s.parts.Parts))
+		for i, cookie := range s.sample {
+			p := s.parts.Of(cookie.ID())
+			sampled[p] = append(sampled[p], i)
+		}
+		for p, indices := range sampled {
+			if len(indices) == 0 {
+				continue
+			}
+			d, release := s.loadShard(s.parts.Path(p))
+			if d == nil {
+				continue
+			}
+			for _, i := range indices {
+				if c := d.Get(s.sample[i].ID()); c != nil {
+					f(i, c)
+				}
+			}
+			release()
+		}
+		return
+	}
+	for _, shard := range s.shards {
+		if !s.mayHold(shard) {
+			continue
+		}
+		d, release := s.loadShard(shard)
+		if d == nil {
+			continue
+		}
+		for i, cookie := range s.sample {
+			if c := d.Get(cookie.ID()); c != nil {
+				f(i, c)
+			}
+		}
+		release()
+	}
+}
+
+//mayHold tells if the shard might hold one of the sampled cookies, shards without a Bloom filter always might
+func (s *dataset) mayHold(shard string) bool {
+	if s.blooms == nil {
+		s.blooms = make(map[string]*cookieDb.Bloom)
+	}
+	b, ok := s.blooms[shard]
+	if !ok {
+		var err error
+		if b, err = cookieDb.ReadBloom(shard); err != nil {
+			if !os.IsNotExist(err) {
+				log.Println("Error while loading bloom filter", err)
+			}
+			b = nil
+		}
+		s.blooms[shard] = b
+	}
+	if b == nil {
+		return true
+	}
+	for _, cookie := range s.sample {
+		if b.MayContain(cookie.ID()) {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *dataset) setSample(size int) {
@@ -37,20 +128,22 @@ func (s *dataset) setSample(size int) {
 }
 
 func (s *dataset) randomElement(shardName string) cookieDb.Cookie {
-	s.loadShard(shardName)
-	r := s.loadedShard.GetElems(1)
+	d, release := s.loadShard(shardName)
+	defer release()
+	if d == nil {
+		errors.Fatal("no shard to sample from")
+	}
+	r := d.GetElems(1)
 	return r[0]
 }
 
-func (s *dataset) loadShard(name string) {
-	if s.loadedShardID != name {
-		shard, err := cookieDb.ReadShard(name)
-		if err != nil {
-			log.Println("Error while loading shard", err)
-		}
-		s.loadedShard = shard
-		s.loadedShardID = name
+//loadShard returns the shard name from the cache, release has to be called when it is no longer used
+func (s *dataset) loadShard(name string) (cookieDb.Shard, func()) {
+	d, release, err := s.cache.Get(name)
+	if err != nil {
+		log.Println("Error while loading shard", err)
 	}
+	return d, release
 }
 
 type count struct {
@@ -60,98 +153,191 @@ type count struct {
 
 func (s *dataset) count() []count {
 	counts := make([]count, s.sampleSize)
-	for _, shard := range s.shards {
-		s.loadShard(shard)
-		for i, cookie := range s.sample {
-			if c := s.loadedShard.Get(cookie.ID()); c != nil {
-				counts[i].count += c.Count()
-				counts[i].id = cookie.ID()
-			}
-		}
-	}
+	s.lookup(func(i int, c cookieDb.Cookie) {
+		counts[i].count += c.Count()
+		counts[i].id = s.sample[i].ID()
+	})
 	return counts
 }
 
 func (s *dataset) countTime() []cookieDb.CountTime {
 	ct := make([]cookieDb.CountTime, s.sampleSize)
-	for _, shard := range s.shards {
-		s.loadShard(shard)
-		for i, cookie := range s.sample {
-			if c := s.loadedShard.Get(cookie.ID()); c != nil {
-				ct[i].Count += c.Count()
-				ct[i].TStamp = append(ct[i].TStamp, c.Time()...)
-			}
-		}
-	}
+	s.lookup(func(i int, c cookieDb.Cookie) {
+		ct[i].Count += c.Count()
+		ct[i].TStamp = append(ct[i].TStamp, c.Time()...)
+	})
 	return ct
 }
 
 func (s *dataset) countTimeCats() []cookieDb.CountTimeCats {
 	ct := make([]cookieDb.CountTimeCats, s.sampleSize)
-	for _, shard := range s.shards {
-		s.loadShard(shard)
-		for i, cookie := range s.sample {
-			if c := s.loadedShard.Get(cookie.ID()); c != nil {
-				ct[i].Counter += c.Count()
-				ct[i].TStamp = append(ct[i].TStamp, c.Time()...)
-				ct[i].Categories = append(ct[i].Categories, c.Cats()...)
-				ct[i].CookieID = cookie.ID()
-			}
-		}
-	}
+	s.lookup(func(i int, c cookieDb.Cookie) {
+		ct[i].Counter += c.Count()
+		ct[i].TStamp = append(ct[i].TStamp, c.Time()...)
+		ct[i].Categories = append(ct[i].Categories, cookieDb.Categories.IDs(c.Cats())...)
+		ct[i].CookieID = s.sample[i].ID()
+	})
 	return ct
 }
 
 func (s *dataset) all() []cookieDb.User {
 	cookies := make([]cookieDb.User, s.sampleSize)
-	for _, shard := range s.shards {
-		s.loadShard(shard)
-		for i, cookie := range s.sample {
-			if c := s.loadedShard.Get(cookie.ID()); c != nil {
-				cookies[i].Sess = append(cookies[i].Sess, c.User().Sess...)
-				cookies[i].CookieID = cookie.ID()
-			}
-		}
-	}
+	s.lookup(func(i int, c cookieDb.Cookie) {
+		cookies[i].Sess = append(cookies[i].Sess, c.User().Sess...)
+		cookies[i].CookieID = s.sample[i].ID()
+	})
 	return cookies
 }
 
 var errors *log.Logger
 
+var fillOptions *cookieDb.FillOptions
+
+var manifest *cookieDb.Manifest
+
+var ingestOptions cookieDb.IngestOptions
+
+var store *cookieDb.Store
+
+var retention cookieDb.Retention
+
 func main() {
 	flag.Parse()
+	if *bloomFP < 0 || *bloomFP >= 1 {
+		errors.Fatal("-bloomFP has to be between 0 and 1, or 0 to write no bloom filters")
+	}
+	cookieDb.MapIndexed = *mmap
+	cookieDb.BloomFPRate = *bloomFP
+	//the commands date and decode shards the way they were built, so the time flags apply to them too
+	var err error
+	if cookieDb.Stamps, err = cookieDb.ParseStampFormat(*stamps); err != nil {
+		errors.Fatal(err)
+	}
+	fallback, err := cookieDb.ParseFallback(*timeFallback)
+	if err != nil {
+		errors.Fatal(err)
+	}
+	cookieDb.FileTimes, err = cookieDb.NewFileTimeExtractor(*timePattern, *timeLayout, fallback)
+	if err != nil {
+		errors.Fatal(err)
+	}
+	if cookieDb.FileTimes.Location, err = time.LoadLocation(*timezone); err != nil {
+		errors.Fatal(err)
+	}
+	if cookieDb.LOC.String() != cookieDb.DefaultTimezone {
+		errors.Println(cookieDb.DefaultTimezone, "is not available, shards without metadata are read in", cookieDb.LOC)
+	}
+	if command, ok := commands[flag.Arg(0)]; ok {
+		if err := command(flag.Args()[1:]); err != nil {
+			errors.Fatal(err)
+		}
+		return
+	}
+	datasetFileNames := flag.Args()
+	if *thirdDir == "" && len(datasetFileNames) == 0 && *follow == "" {
+		panic("no dataset")
+	}
+	var newShard func() cookieDb.Shard
+	if *countFlag && *times && !*catFlag {
+		newShard = func() cookieDb.Shard { set := make(cookieDb.CountTimeSet); return &set }
+	} else if *all {
+		newShard = func() cookieDb.Shard { set := make(cookieDb.StatSet); return &set }
+	} else if *catFlag {
+		newShard = func() cookieDb.Shard { set := make(cookieDb.CountTimeCatsSet); return &set }
+	} else {
+		newShard = func() cookieDb.Shard { set := make(cookieDb.Intersection); return &set }
+	}
+	policy, err := cookieDb.ParsePolicy(*badLines)
+	if err != nil {
+		errors.Fatal(err)
+	}
+	fillOptions = &cookieDb.FillOptions{Policy: policy}
+	storeDir := *storeFlag
+	if storeDir == "" {
+		storeDir = *thirdDir
+	}
+	if storeDir == "" && *follow != "" {
+		storeDir = filepath.Dir(*follow)
+	} else if storeDir == "" && *appendTo != "" {
+		storeDir = filepath.Dir(*appendTo)
+	} else if storeDir == "" {
+		storeDir = filepath.Dir(datasetFileNames[0])
+	}
+	if *recoverStore {
+		rec, err := cookieDb.Recover(storeDir)
+		if err == cookieDb.ErrStoreBusy {
+			log.Println("not recovering", storeDir, err)
+			rec = new(cookieDb.Recovery)
+		} else if err != nil {
+			errors.Fatal(err)
+		}
+		for _, name := range rec.Damaged {
+			log.Println("removed damaged shard", name, "it is rebuilt")
+		}
+		if len(rec.Temps) > 0 {
+			log.Println("removed", len(rec.Temps), "temporary files")
+		}
+	}
+	if store, err = cookieDb.OpenStore(storeDir); err != nil {
+		errors.Fatal(err)
+	}
 	interFileNames := []string{}
 	_ = interFileNames
-	datasetFileNames := []string{}
 	if *firstDir != "" {
 		interFileNames = fromDir(*firstDir)
 	}
 	if *thirdDir != "" {
 		datasetFileNames = fromDir(*thirdDir)
-	} else {
-		datasetFileNames = flag.Args()
-		if len(datasetFileNames) == 0 {
-			panic("no dataset")
+	}
+	if retention, err = cookieDb.LoadRetention(cookieDb.RetentionPath(storeDir)); err != nil {
+		errors.Fatal(err)
+	}
+	if cookieDb.Categories, err = cookieDb.LoadDict(cookieDb.DictPath(storeDir)); err != nil {
+		errors.Fatal(err)
+	}
+	if manifest, err = cookieDb.LoadManifest(cookieDb.ManifestPath(storeDir)); err != nil {
+		errors.Fatal(err)
+	}
+	if fillOptions.Erased, err = cookieDb.LoadErased(storeDir); err != nil {
+		errors.Fatal(err)
+	}
+	ingestOptions = cookieDb.CurrentOptions(policy)
+	if *appendTo != "" {
+		if err := appendShard(*appendTo, newShard().Type(), datasetFileNames); err != nil {
+			errors.Fatal(err)
 		}
+		return
 	}
-	var d cookieDb.Shard
-	if *countFlag && *times && !*catFlag {
-		set := make(cookieDb.CountTimeSet)
-		d = &set
-	} else if *all {
-		set := make(cookieDb.StatSet)
-		d = &set
-	} else if *catFlag {
-		set := make(cookieDb.CountTimeCatsSet)
-		d = &set
-	} else {
-		set := make(cookieDb.Intersection)
-		d = &set
+	if *follow != "" {
+		if err := followShard(*follow, newShard()); err != nil {
+			errors.Fatal(err)
+		}
+		return
+	}
+	if datasetFileNames = unexpired(datasetFileNames, newShard().Type()); len(datasetFileNames) == 0 {
+		errors.Fatal("every file is past the retention of the store")
+	}
+	set, errs := makeShards(datasetFileNames, newShard, *workers)
+	for _, err := range errs {
+		errors.Println(err)
+	}
+	if len(errs) > 0 {
+		errors.Fatal(len(errs), " of ", len(datasetFileNames), " files failed to build")
+	}
+	if *partitions > 0 {
+		if set.parts, err = partition(store.PartitionsDir(), set.shards); err != nil {
+			errors.Fatal(err)
+		}
 	}
-	set := makeShards(datasetFileNames, d)
 	set.setSample(*sampleSize)
 	c := set.all()
-	endTime := cookieDb.ParseTime(datasetFileNames[0]).Add(time.Duration(time.Hour))
+	log.Println("shard cache:", set.cache.Stats())
+	set.cache.Close()
+	fileTime, err := cookieDb.FileTimes.Extract(datasetFileNames[0])
+	if err != nil {
+		errors.Fatal(err)
+	}
+	endTime := fileTime.Add(time.Duration(time.Hour))
 	startTime := endTime.Add(time.Duration(time.Hour * time.Duration(*timeFrame+1) * -1))
 	f, err := os.Create("output.txt")
 	if err != nil {
@@ -168,37 +354,241 @@ func main() {
 	fmt.Println(float64(count) / float64(*sampleSize))
 }
 
-func shardAlreadyMade(shardName string) bool {
-	if _, err := os.Stat(shardName); os.IsNotExist(err) {
-		return false
+//shardAlreadyMade tells if shardName exists and was built from the current contents of name with the current options
+func shardAlreadyMade(name, shardName, shardType string) bool {
+	fresh, err := manifest.Fresh(shardName, shardType, name, ingestOptions)
+	if err != nil {
+		log.Println(err)
+	}
+	if fresh && store.Entry(name, shardType) == nil {
+		if _, err := store.Record(name, shardType); err != nil {
+			log.Println(err)
+			return false
+		}
 	}
-	return true
+	return fresh
 }
 
-func makeShards(fileNames []string, d cookieDb.Shard) (set *dataset) {
-	set = new(dataset)
-	for _, name := range fileNames {
-		shardName := name + "." + d.Type() + ".gob"
-		if !shardAlreadyMade(shardName) {
-			f, err := os.Open(name)
-			if err != nil {
-				panic(err)
+func shardMeta(name string, d cookieDb.Shard) *cookieDb.Meta {
+	return &cookieDb.Meta{Type: d.Type(), Source: name, Timezone: cookieDb.FileTimes.Loc().String()}
+}
+
+//buildShard fills d from the log file name and puts it in the store as shardName. The late files that were
+//appended to the shard before are appended again, it is not rebuilt when one of them is gone.
+func buildShard(name, shardName string, d cookieDb.Shard) error {
+	fileTime, err := cookieDb.FileTimes.Extract(name)
+	if err != nil {
+		return err
+	}
+	var late []string
+	if e := manifest.Entry(shardName); e != nil {
+		for _, src := range e.Appended {
+			if _, err := os.Stat(src.Path); err != nil {
+				return fmt.Errorf("%s can not be rebuilt without the late lines appended from %s: %v", shardName, src.Path, err)
 			}
-			d = cookieDb.FillDb(bufio.NewScanner(f), d, shardName)
-			f.Close()
-			if err := cookieDb.WriteShard(shardName, d); err != nil {
-				log.Println(err)
-			} else {
-				set.shards = append(set.shards, shardName)
+			late = append(late, src.Path)
+		}
+	}
+	cookieDb.FileTimes.Remember(shardName, fileTime)
+	f, err := cookieDb.OpenInput(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts := *fillOptions
+	quarantine := cookieDb.NewQuarantine(cookieDb.QuarantinePath(shardName))
+	if opts.Policy == cookieDb.QuarantineBadLines {
+		opts.Quarantine = quarantine
+	}
+	d, report, err := cookieDb.FillDb(bufio.NewScanner(f), d, shardName, &opts)
+	if qerr := quarantine.Close(); qerr != nil {
+		log.Println(qerr)
+	}
+	if err != nil {
+		return err
+	}
+	report.File = name
+	if err := report.WriteFile(cookieDb.ReportPath(shardName)); err != nil {
+		log.Println(err)
+	}
+	if report.Rejected > 0 {
+		log.Println(name, "rejected", report.Rejected, "of", report.Lines, "lines", report.Reasons)
+	}
+	if _, err := store.Put(name, d, *indexed); err != nil {
+		return err
+	}
+	if err := manifest.Record(shardName, d.Type(), name, ingestOptions); err != nil {
+		return err
+	}
+	if len(late) == 0 {
+		return nil
+	}
+	return appendShard(name, d.Type(), late)
+}
+
+//followShard keeps filling d from the log file name until its hour is over
+func followShard(name string, d cookieDb.Shard) error {
+	shardName := store.Path(name, d.Type())
+	opts := &cookieDb.FollowOptions{FillOptions: *fillOptions, Checkpoint: *checkpoint}
+	quarantine := cookieDb.NewQuarantine(cookieDb.QuarantinePath(shardName))
+	if opts.Policy == cookieDb.QuarantineBadLines {
+		opts.Quarantine = quarantine
+	}
+	if err := cookieDb.WriteMeta(shardName, shardMeta(name, d)); err != nil {
+		return err
+	}
+	_, report, err := cookieDb.Follow(name, d, shardName, opts)
+	if qerr := quarantine.Close(); qerr != nil {
+		log.Println(qerr)
+	}
+	if err != nil {
+		return err
+	}
+	report.File = name
+	if err := report.WriteFile(cookieDb.ReportPath(shardName)); err != nil {
+		log.Println(err)
+	}
+	if _, err := store.Record(name, d.Type()); err != nil {
+		return err
+	}
+	return manifest.Record(shardName, d.Type(), name, ingestOptions)
+}
+
+//appendShard folds the lines of the late files into the shard built from the log file name
+func appendShard(name, shardType string, late []string) error {
+	shardName := store.Path(name, shardType)
+	fileTime, err := cookieDb.FileTimes.Extract(name)
+	if err != nil {
+		return err
+	}
+	cookieDb.FileTimes.Remember(shardName, fileTime)
+	for _, lateName := range late {
+		appended, err := manifest.Appended(shardName, lateName)
+		if err != nil {
+			return err
+		}
+		if appended {
+			log.Println(lateName, "is already appended to", shardName)
+			continue
+		}
+		f, err := cookieDb.OpenInput(lateName)
+		if err != nil {
+			return err
+		}
+		opts := *fillOptions
+		quarantine := cookieDb.NewQuarantine(cookieDb.QuarantinePath(store.Path(lateName, shardType)))
+		if opts.Policy == cookieDb.QuarantineBadLines {
+			opts.Quarantine = quarantine
+		}
+		report, err := cookieDb.AppendShard(shardName, lateName, bufio.NewScanner(f), &opts)
+		f.Close()
+		if qerr := quarantine.Close(); qerr != nil {
+			log.Println(qerr)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", lateName, err)
+		}
+		if report.Rejected > 0 {
+			log.Println(lateName, "rejected", report.Rejected, "of", report.Lines, "lines", report.Reasons)
+		}
+		if err := manifest.RecordAppend(shardName, lateName); err != nil {
+			return err
+		}
+	}
+	_, err = store.Record(name, shardType)
+	return err
+}
+
+//makeShards builds the missing shards of fileNames with the given number of workers,
+//each worker fills its own Shard made by newShard. The shards keep the order of fileNames,
+//logs that were compacted into one shard by the compact command load it once in their place.
+func makeShards(fileNames []string, newShard func() cookieDb.Shard, workers int) (set *dataset, errs []error) {
+	if workers < 1 {
+		workers = 1
+	}
+	shardType := newShard().Type()
+	built := make([]bool, len(fileNames))
+	failed := make([]error, len(fileNames))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := newShard()
+			for i := range jobs {
+				name := fileNames[i]
+				if store.Merged(name, shardType) != nil {
+					built[i] = true
+					continue
+				}
+				shardName := store.Path(name, shardType)
+				if shardAlreadyMade(name, shardName, shardType) {
+					built[i] = true
+					continue
+				}
+				d.Init()
+				if err := buildShard(name, shardName, d); err != nil {
+					failed[i] = fmt.Errorf("%s: %v", name, err)
+					continue
+				}
+				built[i] = true
 			}
-			d.Init()
-		} else {
-			set.shards = append(set.shards, shardName)
+		}()
+	}
+	for i := range fileNames {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	set = &dataset{cache: cookieDb.NewShardCache(*cacheBytes)}
+	loaded := make(map[string]bool)
+	for i, name := range fileNames {
+		e := store.Merged(name, shardType)
+		if e == nil {
+			e = store.Entry(name, shardType)
+		}
+		switch {
+		case !built[i]:
+			errs = append(errs, failed[i])
+		case e == nil:
+			errs = append(errs, fmt.Errorf("%s: shard is not in the catalog of the store", name))
+		case !loaded[e.File]:
+			loaded[e.File] = true
+			set.shards = append(set.shards, store.File(e))
 		}
 	}
 	return
 }
 
+//partition returns the partitions of shards in dir, they are rebuilt when they are missing or stale
+func partition(dir string, shards []string) (*cookieDb.Partitions, error) {
+	if p, err := cookieDb.OpenPartitions(dir); err == nil && !p.Stale(shards, *partitions) {
+		return p, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+	return cookieDb.PartitionShards(dir, *partitions, shards, *indexed)
+}
+
+//unexpired leaves out the logs whose hour is past the retention of shardType, gc would drop their shards again
+func unexpired(fileNames []string, shardType string) []string {
+	cutoff, ok := retention.Cutoff(shardType, time.Now())
+	if !ok {
+		return fileNames
+	}
+	var kept []string
+	for _, name := range fileNames {
+		if t, err := cookieDb.FileTimes.Extract(name); err == nil && t.Add(time.Hour).Before(cutoff) {
+			log.Println("skipping", name, "it is past the retention of", shardType)
+			continue
+		}
+		kept = append(kept, name)
+	}
+	return kept
+}
+
+//fromDir returns the logs in dir, the files the store wrote there are left out
 func fromDir(dir string) []string {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -206,6 +596,9 @@ func fromDir(dir string) []string {
 	}
 	filePaths := []string{}
 	for _, fileInfo := range files {
+		if fileInfo.IsDir() || store.Owns(filepath.Join(dir, fileInfo.Name())) {
+			continue
+		}
 		filePaths = append(filePaths, dir+"/"+fileInfo.Name())
 	}
 	return filePaths